@@ -0,0 +1,97 @@
+package game
+
+// Adversarial set-up selection: instead of picking any solution of a template,
+// pick one of the solutions that take reference shooters the most shots.
+
+import (
+	"rand"
+	"sort"
+)
+
+// Parameters of the adversarial set-up policy:
+var (
+	AdversarialCandidates = 500 // maximum number of solutions evaluated per template
+	AdversarialRuns       = 3   // number of play-outs per randomized shooter
+	AdversarialFraction   = 0.1 // fraction of hardest candidates to choose from
+)
+
+// referenceShooters are the randomized shooters candidate fields are scored
+// against, besides the greedy strategy.
+var referenceShooters = []ShootFunc{simpleShoot, huntShoot}
+
+// scoredField is a candidate field with its difficulty score.
+type scoredField struct {
+	field *Field
+	score float
+}
+
+type scoredFields []scoredField
+
+func (sf scoredFields) Len() int           { return len(sf) }
+func (sf scoredFields) Less(i, j int) bool { return sf[i].score > sf[j].score }
+func (sf scoredFields) Swap(i, j int)      { sf[i], sf[j] = sf[j], sf[i] }
+
+// ScoreField returns the average number of shots that the reference shooters
+// need to sink the given field: the greedy strategy for the solution set, the
+// simple shooter and the hunt-and-target shooter.
+func ScoreField(rng *rand.Rand, strategy *Strategy, field *Field) float {
+	total := float(GetFieldScore(strategy, field))
+	for _, shooter := range (referenceShooters) {
+		var shots int
+		for i := 0; i < AdversarialRuns; i++ {
+			shots += PlayOut(shooter, rng, field)
+		}
+		total += float(shots) / float(AdversarialRuns)
+	}
+	return total / float(1+len(referenceShooters))
+}
+
+// HardFields returns the hardest solutions of the template, as scored by
+// ScoreField. These are computed only once, using the given random number
+// generator, and then kept in memory.
+func (t *Template) HardFields(rng *rand.Rand) []*Field {
+	t.hardMutex.Lock()
+	defer t.hardMutex.Unlock()
+	if t.hard != nil {
+		return t.hard
+	}
+	solutions := t.Solutions()
+	if len(solutions) == 0 {
+		return nil
+	}
+
+	// CreateStrategy reorders its argument, so pass it a copy:
+	fields := make([]*Field, len(solutions))
+	copy(fields, solutions)
+	strategy := CreateStrategy(rng, fields)
+
+	n := len(solutions)
+	if n > AdversarialCandidates {
+		n = AdversarialCandidates
+	}
+	candidates := make(scoredFields, n)
+	for i, j := range (rng.Perm(len(solutions))[0:n]) {
+		candidates[i] = scoredField{solutions[j], ScoreField(rng, strategy, solutions[j])}
+	}
+	sort.Sort(candidates)
+
+	k := int(AdversarialFraction*float(n) + 0.5)
+	if k < 1 {
+		k = 1
+	}
+	t.hard = make([]*Field, k)
+	for i := range (t.hard) {
+		t.hard[i] = candidates[i].field
+	}
+	return t.hard
+}
+
+// adversarialSetup selects a random field among the hardest solutions of a
+// random template, under a random symmetry of the board.
+func adversarialSetup(rng *rand.Rand) *Field {
+	hard := selectTemplate(rng, Templates).HardFields(rng)
+	if len(hard) == 0 {
+		return RandomField(rng) // unsolvable template
+	}
+	return randomTransform(rng).Field(hard[rng.Intn(len(hard))])
+}
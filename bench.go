@@ -0,0 +1,68 @@
+package main
+
+// A tool to evaluate engines by letting them play against each other.
+
+import (
+	"./game"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// lookupEngine returns the named engine, or exits if it doesn't exist.
+func lookupEngine(name string) *game.Engine {
+	engine := game.LookupEngine(name)
+	if engine == nil {
+		fmt.Fprintln(os.Stderr, "Unknown engine:", name)
+		os.Exit(1)
+	}
+	return engine
+}
+
+func main() {
+	// Parse command line arguments:
+	first := flag.String("a", "default", "first engine")
+	second := flag.String("b", "simple", "second engine")
+	ladder := flag.Bool("l", false, "play the first engine against the whole ladder")
+	games := flag.Int("n", 100, "number of games to play")
+	seed := flag.Int64("s", 0, "random seed (0 to pick at random)")
+	workers := flag.Int("w", 4, "number of concurrent workers")
+	flag.FloatVar(&game.TimeOut, "t", game.TimeOut, "maximum time to spend on solving")
+	templates := flag.String("T", "", "file with set-up templates")
+	mixture := flag.String("M", "", "file with a mixed set-up strategy")
+	rules := game.RegisterRuleFlags(false)
+	flag.Parse()
+	if err := rules.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *templates != "" {
+		if err := game.LoadTemplates(*templates); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load templates:", err)
+			os.Exit(1)
+		}
+	}
+	if *mixture != "" {
+		if err := game.LoadMixture(*mixture); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load mixture:", err)
+			os.Exit(1)
+		}
+	}
+
+	opponents := []string{*second}
+	if *ladder {
+		opponents = game.Ladder
+	}
+	a := lookupEngine(*first)
+	if *seed == 0 {
+		*seed = time.Nanoseconds()
+	}
+
+	runtime.GOMAXPROCS(*workers)
+	fmt.Println("Seed:", *seed)
+	for _, name := range (opponents) {
+		fmt.Print(game.Simulate(a, lookupEngine(name), *games, *seed, *workers))
+	}
+}
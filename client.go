@@ -0,0 +1,164 @@
+package client
+
+// A client for the player HTTP protocol implemented by the server. Referees and
+// other tools can use it to play against remote players.
+
+import (
+	"./game"
+	"http"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlayerClient talks to a player at the given URL.
+type PlayerClient struct {
+	URL     string // root path of the player, e.g. "http://localhost:14000/player"
+	Timeout int64  // maximum time to wait for a response in ns (0 for no limit)
+}
+
+// Error describes a failed request to a player.
+type Error struct {
+	Action   string // the action that failed
+	Message  string // error message reported by the player or the client
+	TimedOut bool   // whether the player failed to respond in time
+}
+
+func (e *Error) String() string {
+	if e.TimedOut {
+		return e.Action + ": timed out"
+	}
+	return e.Action + ": " + e.Message
+}
+
+// NewPlayerClient returns a client for the player at the given URL, with a
+// default time-out of 5 seconds.
+func NewPlayerClient(url string) *PlayerClient {
+	return &PlayerClient{url, 5e9}
+}
+
+// response holds the result of an HTTP request
+type response struct {
+	body string
+	err  os.Error
+}
+
+// get performs an HTTP GET request without a time-out.
+func get(url string) (body string, err os.Error) {
+	r, _, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// request sends a request with the given action and parameters to the player,
+// and returns the body of its response if the request succeeded.
+func (pc *PlayerClient) request(action string, params map[string]string) (string, os.Error) {
+	url := pc.URL + "?Action=" + http.URLEscape(action)
+	for name, value := range (params) {
+		url += "&" + name + "=" + http.URLEscape(value)
+	}
+	done := make(chan response, 1)
+	go func() {
+		body, err := get(url)
+		done <- response{body, err}
+	}()
+	var res response
+	if pc.Timeout > 0 {
+		ticker := time.NewTicker(pc.Timeout)
+		select {
+		case res = <-done:
+		case <-ticker.C:
+			ticker.Stop()
+			return "", &Error{action, "", true}
+		}
+		ticker.Stop()
+	} else {
+		res = <-done
+	}
+	if res.err != nil {
+		return "", &Error{action, res.err.String(), false}
+	}
+	if strings.HasPrefix(res.body, "ERROR: ") {
+		msg := strings.TrimSpace(res.body[len("ERROR: "):])
+		if strings.HasSuffix(msg, "!") {
+			msg = msg[0 : len(msg)-1]
+		}
+		return "", &Error{action, msg, false}
+	}
+	return strings.TrimSpace(res.body), nil
+}
+
+// Ships requests a new set-up from the player, and checks that it is valid.
+func (pc *PlayerClient) Ships() (*game.Field, os.Error) {
+	body, err := pc.request("Ships", nil)
+	if err != nil {
+		return nil, err
+	}
+	field := game.ParseShips(body)
+	if field == nil {
+		return nil, &Error{"Ships", "invalid ship data: " + body, false}
+	}
+	if _, violations := game.ValidateFleet(field); violations != nil {
+		return nil, &Error{"Ships", "invalid fleet: " + strings.Join(violations, "; "), false}
+	}
+	return field, nil
+}
+
+// Fire requests the player's next move, given the opponent's counts and the
+// shots fired so far.
+func (pc *PlayerClient) Fire(rows *game.RowCounts, cols *game.ColCounts, shots []game.Shot) (r, c int, err os.Error) {
+	body, err := pc.request("Fire", map[string]string{
+		"Rows":  game.FormatCounts(rows),
+		"Cols":  game.FormatCounts(cols),
+		"Shots": game.FormatShots(shots),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(body) < 2 {
+		return 0, 0, &Error{"Fire", "invalid coordinates: " + body, false}
+	}
+	r, c, ok := game.ParseCoords(body)
+	if !ok {
+		return 0, 0, &Error{"Fire", "invalid coordinates: " + body, false}
+	}
+	return r, c, nil
+}
+
+// FireSalvo requests the player's next volley of n shots in the salvo variant.
+// The player may return fewer shots if fewer cells are left to fire at, and
+// no shots at all if none are left (or n is 0).
+func (pc *PlayerClient) FireSalvo(rows *game.RowCounts, cols *game.ColCounts, shots []game.Shot, n int) ([][2]int, os.Error) {
+	if n == 0 {
+		return make([][2]int, 0), nil
+	}
+	body, err := pc.request("Fire", map[string]string{
+		"Rows":  game.FormatCounts(rows),
+		"Cols":  game.FormatCounts(cols),
+		"Shots": game.FormatShots(shots),
+		"Salvo": strconv.Itoa(n),
+	})
+	if err != nil {
+		return nil, err
+	}
+	volley := game.ParseVolley(body)
+	if volley == nil || len(volley) > n {
+		return nil, &Error{"Fire", "invalid volley: " + body, false}
+	}
+	return volley, nil
+}
+
+// Finished tells the player that the game is over, revealing the opponent's ships.
+func (pc *PlayerClient) Finished(ships *game.Field) os.Error {
+	_, err := pc.request("Finished", map[string]string{"Ships": game.FormatShips(ships)})
+	return err
+}
@@ -1,18 +1,30 @@
-BINS=test server generator
-OBJS=generator.$X game.$X server.$X test.$X util.$X
-GAME_SRC=game.go io.go player.go solver.go
+BINS=test server generator bench engine optimize corpus solitaire
+OBJS=client.$X corpus.$X records.$X generator.$X game.$X server.$X test.$X util.$X bench.$X engine.$X optimize.$X solitaire.$X
+GAME_SRC=adversarial.go deduce.go engines.go fleet.go game.go io.go match.go mixed.go player.go puzzle.go rules.go salvo.go simulation.go solver.go symmetry.go templates.go validate.go
 
-all: $(BINS)
+all: $(BINS) client.$X
 
 util.$X: util.go; $C -o $@ $<
+client.$X: client.go game.$X; $C -o $@ $<
 game.$X: $(GAME_SRC) util.$X;  $C -o $@ $(GAME_SRC)
-generator.$X: generator.go game.$X; $C -o $@ $<
+records.$X: records.go game.$X; $C -o $@ $<
+generator.$X: generator.go records.$X game.$X; $C -o $@ $<
 server.$X: server.go game.$X; $C -o $@ $<
 test.$X: test.go game.$X; $C -o $@ $<
+bench.$X: bench.go game.$X; $C -o $@ $<
+engine.$X: engine.go game.$X; $C -o $@ $<
+optimize.$X: optimize.go game.$X; $C -o $@ $<
+corpus.$X: corpus.go records.$X game.$X; $C -o $@ $<
+solitaire.$X: solitaire.go game.$X; $C -o $@ $<
 
 test: game.$X test.$X; $L -o $@ test.$X
 server: game.$X server.$X; $L -o $@ server.$X
-generator: game.$X generator.$X; $L -o $@ generator.$X 
+generator: game.$X records.$X generator.$X; $L -o $@ generator.$X 
+bench: game.$X bench.$X; $L -o $@ bench.$X
+engine: game.$X engine.$X; $L -o $@ engine.$X
+optimize: game.$X optimize.$X; $L -o $@ optimize.$X
+corpus: game.$X records.$X corpus.$X; $L -o $@ corpus.$X
+solitaire: game.$X solitaire.$X; $L -o $@ solitaire.$X
 
 clean: ; rm -f $(OBJS)
 distclean: clean; rm -f $(BINS)
@@ -0,0 +1,142 @@
+package main
+
+// A tool to maintain a corpus of set-up templates. Usage:
+//
+//   corpus [flags] import <file>...  add generator output to the corpus
+//   corpus [flags] query             list matching records
+//   corpus [flags] sample            list a random sample of matching records
+//   corpus [flags] export            write matching records as templates
+//
+// Records are deduplicated by the canonical signature of their counts.
+
+import (
+	"./records"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"rand"
+	"strings"
+	"time"
+)
+
+// fail prints an error message and exits.
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
+
+func main() {
+	// Parse command line arguments:
+	filename := flag.String("c", "corpus.jsonl", "corpus file")
+	where := flag.String("where", "", "conditions on metrics, e.g. \"expected>=60,depth>70\"")
+	sortBy := flag.String("sort", "", "metric to sort by, in decreasing order")
+	limit := flag.Int("n", 0, "maximum number of records to list (0 for no limit)")
+	seed := flag.Int64("s", 0, "random seed for sampling (0 to pick at random) and measuring")
+	output := flag.String("o", "", "output file (default: standard output)")
+	measure := flag.Bool("measure", false, "compute missing metrics when importing")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	corpus, err := records.Load(*filename)
+	if err != nil {
+		fail("Could not load corpus: " + err.String())
+	}
+	conds, err := records.ParseConditions(*where)
+	if err != nil {
+		fail(err.String())
+	}
+	if *sortBy != "" {
+		if _, ok := (&records.Record{}).Metric(*sortBy); !ok {
+			fail("Unknown metric: " + *sortBy)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "import":
+		added, total := 0, 0
+		for i := 1; i < flag.NArg(); i++ {
+			data, err := io.ReadFile(flag.Arg(i))
+			if err != nil {
+				fail("Could not read file: " + err.String())
+			}
+			for j, line := range (strings.Split(string(data), "\n", 0)) {
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				r := records.Parse(line)
+				if r == nil {
+					fail(fmt.Sprintf("%s:%d: invalid record", flag.Arg(i), j+1))
+				}
+				if *measure && !r.Measured() {
+					r.Measure(rand.New(rand.NewSource(*seed)))
+				}
+				total++
+				if corpus.Add(r) {
+					added++
+				}
+			}
+		}
+		if err := corpus.Save(*filename); err != nil {
+			fail("Could not save corpus: " + err.String())
+		}
+		fmt.Printf("%d of %d records added; corpus contains %d records\n", added, total, corpus.Len())
+
+	case "query", "sample", "export":
+		var selected []*records.Record
+		{
+			all := corpus.Records()
+			selected = make([]*records.Record, 0, len(all))
+			for _, r := range (all) {
+				if r.Matches(conds) {
+					selected = selected[0 : len(selected)+1]
+					selected[len(selected)-1] = r
+				}
+			}
+		}
+		truncate := func() {
+			if *limit > 0 && len(selected) > *limit {
+				selected = selected[0:*limit]
+			}
+		}
+		if flag.Arg(0) == "sample" {
+			// Shuffle, then take the first records:
+			if *seed == 0 {
+				*seed = time.Nanoseconds()
+			}
+			rng := rand.New(rand.NewSource(*seed))
+			for i := len(selected) - 1; i > 0; i-- {
+				j := rng.Intn(i + 1)
+				selected[i], selected[j] = selected[j], selected[i]
+			}
+			truncate()
+		}
+		if *sortBy != "" {
+			records.Sort(selected, *sortBy)
+		}
+		truncate()
+
+		out := os.Stdout
+		if *output != "" {
+			out, err = os.Open(*output, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+			if err != nil {
+				fail("Could not open output file: " + err.String())
+			}
+			defer out.Close()
+		}
+		for _, r := range (selected) {
+			if flag.Arg(0) == "export" {
+				// The text format can be loaded as templates by game.LoadTemplates
+				fmt.Fprintln(out, r.Text())
+			} else {
+				fmt.Fprintln(out, r.JSON())
+			}
+		}
+
+	default:
+		fail("Unknown command: " + flag.Arg(0))
+	}
+}
@@ -0,0 +1,445 @@
+package game
+
+// A propagation solver that mimics how a human solves a puzzle: it repeatedly
+// applies simple named deduction rules, and records each step with a reason.
+// Unlike the enumerating solver, it may get stuck on hard puzzles; the rules
+// it needs to solve a puzzle are a measure of the puzzle's difficulty.
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CellState describes what is known about a cell.
+type CellState int
+
+const (
+	Unknown CellState = iota
+	Water
+	Segment // a ship segment
+)
+
+// A Grid records what is known about each cell of a field.
+type Grid [FieldHeight][FieldWidth]CellState
+
+// A Step records a single deduction: the state of a cell was determined by
+// applying the named rule.
+type Step struct {
+	Rule   string
+	R, C   int
+	State  CellState
+	Reason string
+}
+
+func (s Step) String() string {
+	what := "water"
+	if s.State == Segment {
+		what = "ship"
+	}
+	return fmt.Sprintf("%s is %s (%s: %s)", FormatCoords(s.R, s.C), what, s.Rule, s.Reason)
+}
+
+// Deduction rules, and their difficulty (see Deduction.Grade):
+var ruleLevels = map[string]int{
+	"hint":        0,
+	"land":        0,
+	"shape":       Easy,
+	"row-full":    Easy,
+	"col-full":    Easy,
+	"row-rest":    Easy,
+	"col-rest":    Easy,
+	"diagonal":    Easy,
+	"max-length":  Medium,
+	"unreachable": Medium,
+	"largest":     Hard,
+}
+
+// A Deduction holds the state of the propagation solver.
+type Deduction struct {
+	Rows  RowCounts
+	Cols  ColCounts
+	Hints []Hint
+	Grid  Grid
+	Steps []Step
+	level int // hardest rule level used so far
+}
+
+// set records that cell r,c has the given state, if it was unknown before.
+func (d *Deduction) set(rule string, r, c int, state CellState, reason string) bool {
+	if d.Grid[r][c] != Unknown {
+		return false
+	}
+	d.Grid[r][c] = state
+	if len(d.Steps) == cap(d.Steps) {
+		tmp := make([]Step, len(d.Steps), 2*len(d.Steps)+16)
+		copy(tmp, d.Steps)
+		d.Steps = tmp
+	}
+	d.Steps = d.Steps[0 : len(d.Steps)+1]
+	d.Steps[len(d.Steps)-1] = Step{rule, r, c, state, reason}
+	if ruleLevels[rule] > d.level {
+		d.level = ruleLevels[rule]
+	}
+	return true
+}
+
+// Deduce applies the deduction rules to the given counts and hints until the
+// puzzle is solved, or no more rules apply.
+func Deduce(rows RowCounts, cols ColCounts, hints []Hint) *Deduction {
+	d := &Deduction{Rows: rows, Cols: cols, Hints: hints}
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if GameRules.Land[r][c] {
+				d.set("land", r, c, Water, "land")
+			}
+		}
+	}
+	for _, hint := range (hints) {
+		if hint.Given.Ship() {
+			d.set("hint", hint.R, hint.C, Segment, "given")
+		} else {
+			d.set("hint", hint.R, hint.C, Water, "given")
+		}
+	}
+	rules := []func() bool{func() bool { return d.countRules() }}
+	if GameRules.Adjacency == NoTouch && straightFleet() {
+		// The remaining rules identify ships as runs of segments:
+		rules = []func() bool{
+			func() bool { return d.shapeRule() },
+			rules[0],
+			func() bool { return d.diagonalRule() },
+			func() bool { return d.maxLengthRule() },
+			func() bool { return d.unreachableRule() },
+			func() bool { return d.largestRule() },
+		}
+	}
+	for !d.Solved() {
+		progress := false
+		for _, rule := range (rules) {
+			if rule() {
+				progress = true
+				break // restart with the simplest rules
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return d
+}
+
+// Solved returns whether the state of every cell has been determined.
+func (d *Deduction) Solved() bool {
+	for r := range (d.Grid) {
+		for c := range (d.Grid[r]) {
+			if d.Grid[r][c] == Unknown {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Field returns the ship segments found so far.
+func (d *Deduction) Field() *Field {
+	var field Field
+	for r := range (d.Grid) {
+		for c := range (d.Grid[r]) {
+			field[r][c] = d.Grid[r][c] == Segment
+		}
+	}
+	return &field
+}
+
+// Grade returns the difficulty level of the puzzle (Easy, Medium or Hard) as
+// the level of the hardest rule needed to solve it, or -1 if the solver got
+// stuck.
+func (d *Deduction) Grade() int {
+	if !d.Solved() {
+		return -1
+	}
+	return d.level
+}
+
+// GradeName returns the name of the puzzle's grade.
+func (d *Deduction) GradeName() string {
+	if grade := d.Grade(); grade >= 0 {
+		return difficultyNames[grade]
+	}
+	return "stuck"
+}
+
+// count returns the number of cells with the given state in a row or column.
+func (d *Deduction) count(r, c, dr, dc, n int, state CellState) (count int) {
+	for i := 0; i < n; i++ {
+		if d.Grid[r+i*dr][c+i*dc] == state {
+			count++
+		}
+	}
+	return
+}
+
+// countRules fills rows and columns whose count is reached with water, and
+// rows and columns that need all their unknown cells with ship segments.
+func (d *Deduction) countRules() (progress bool) {
+	for r := 0; r < FieldHeight; r++ {
+		ships, unknown := d.count(r, 0, 0, 1, FieldWidth, Segment), d.count(r, 0, 0, 1, FieldWidth, Unknown)
+		for c := 0; c < FieldWidth && unknown > 0; c++ {
+			if ships == d.Rows[r] {
+				progress = d.set("row-full", r, c, Water, "row "+strconv.Itoa(r+1)+" has all its "+strconv.Itoa(ships)+" segments") || progress
+			} else if ships+unknown == d.Rows[r] {
+				progress = d.set("row-rest", r, c, Segment, "row "+strconv.Itoa(r+1)+" needs all remaining cells") || progress
+			}
+		}
+	}
+	for c := 0; c < FieldWidth; c++ {
+		ships, unknown := d.count(0, c, 1, 0, FieldHeight, Segment), d.count(0, c, 1, 0, FieldHeight, Unknown)
+		for r := 0; r < FieldHeight && unknown > 0; r++ {
+			if ships == d.Cols[c] {
+				progress = d.set("col-full", r, c, Water, "column "+string('A'+c)+" has all its "+strconv.Itoa(ships)+" segments") || progress
+			} else if ships+unknown == d.Cols[c] {
+				progress = d.set("col-rest", r, c, Segment, "column "+string('A'+c)+" needs all remaining cells") || progress
+			}
+		}
+	}
+	return
+}
+
+// shapeRule completes the surroundings of revealed ship segments with a known
+// shape: the cells beyond an end are water, and the cells towards the rest of
+// the ship are segments. A middle segment continues on both sides, in the
+// direction that is not blocked.
+func (d *Deduction) shapeRule() (progress bool) {
+	for _, hint := range (d.Hints) {
+		r, c := hint.R, hint.C
+		reason := "next to the given " + string(byte(hint.Given)) + " at " + FormatCoords(r, c)
+		mark := func(dr, dc int, state CellState) {
+			if inField(r+dr, c+dc) {
+				progress = d.set("shape", r+dr, c+dc, state, reason) || progress
+			}
+		}
+		switch hint.Given {
+		case GivenSub:
+			mark(-1, 0, Water)
+			mark(1, 0, Water)
+			mark(0, -1, Water)
+			mark(0, 1, Water)
+		case GivenLeft:
+			mark(0, -1, Water)
+			mark(0, 1, Segment)
+		case GivenRight:
+			mark(0, 1, Water)
+			mark(0, -1, Segment)
+		case GivenTop:
+			mark(-1, 0, Water)
+			mark(1, 0, Segment)
+		case GivenBottom:
+			mark(1, 0, Water)
+			mark(-1, 0, Segment)
+		case GivenMiddle:
+			if d.blocked(r, c-1) || d.blocked(r, c+1) || d.isSegment(r-1, c) || d.isSegment(r+1, c) {
+				mark(-1, 0, Segment)
+				mark(1, 0, Segment)
+			}
+			if d.blocked(r-1, c) || d.blocked(r+1, c) || d.isSegment(r, c-1) || d.isSegment(r, c+1) {
+				mark(0, -1, Segment)
+				mark(0, 1, Segment)
+			}
+		}
+	}
+	return
+}
+
+// diagonalRule marks cells diagonal to a ship segment as water, since ships
+// never touch.
+func (d *Deduction) diagonalRule() (progress bool) {
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if d.Grid[r][c] != Segment {
+				continue
+			}
+			for dr := -1; dr <= 1; dr += 2 {
+				for dc := -1; dc <= 1; dc += 2 {
+					if inField(r+dr, c+dc) {
+						progress = d.set("diagonal", r+dr, c+dc, Water, "diagonal to ship at "+FormatCoords(r, c)) || progress
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// blocked returns whether cell r,c is outside the field or known to be water.
+func (d *Deduction) blocked(r, c int) bool { return !inField(r, c) || d.Grid[r][c] == Water }
+
+// isSegment returns whether cell r,c is inside the field and a ship segment.
+func (d *Deduction) isSegment(r, c int) bool { return inField(r, c) && d.Grid[r][c] == Segment }
+
+// remainingShips returns the lengths of the ships that have not been found
+// completely yet (i.e. as a run of segments enclosed by water), longest first.
+func (d *Deduction) remainingShips() []int {
+	var found [FieldHeight + FieldWidth]int
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if d.Grid[r][c] != Segment || d.isSegment(r-1, c) || d.isSegment(r, c-1) {
+				continue // not the top-left end of a run
+			}
+			length, dr, dc := 1, 0, 1
+			if !d.isSegment(r, c+1) {
+				dr, dc = 1, 0
+			}
+			for d.isSegment(r+length*dr, c+length*dc) {
+				length++
+			}
+			if d.blocked(r-dr, c-dc) && d.blocked(r+length*dr, c+length*dc) &&
+				(length > 1 || d.blocked(r, c-1) && d.blocked(r, c+1)) {
+				found[length]++
+			}
+		}
+	}
+	remaining := make([]int, 0, len(GameRules.Fleet))
+	for _, shape := range (GameRules.Fleet) {
+		length := shape.Size()
+		if found[length] > 0 {
+			found[length]--
+		} else {
+			remaining = remaining[0 : len(remaining)+1]
+			remaining[len(remaining)-1] = length
+		}
+	}
+	return remaining
+}
+
+// fits returns whether a ship of the given length can be placed at r,c in the
+// given direction, consistent with what is known: it does not cover water, does
+// not extend a run of segments beyond its ends, and does not exceed the counts.
+func (d *Deduction) fits(r, c, dr, dc, length int) bool {
+	if !inField(r+(length-1)*dr, c+(length-1)*dc) {
+		return false
+	}
+	if d.isSegment(r-dr, c-dc) || d.isSegment(r+length*dr, c+length*dc) {
+		return false
+	}
+	unknown := 0
+	for i := 0; i < length; i++ {
+		r1, c1 := r+i*dr, c+i*dc
+		switch d.Grid[r1][c1] {
+		case Water:
+			return false
+		case Unknown:
+			unknown++
+			if dr == 1 && d.count(r1, 0, 0, 1, FieldWidth, Segment) >= d.Rows[r1] {
+				return false
+			}
+			if dc == 1 && d.count(0, c1, 1, 0, FieldHeight, Segment) >= d.Cols[c1] {
+				return false
+			}
+		}
+		// Cells alongside the ship may not contain segments:
+		if d.isSegment(r1+dc, c1+dr) || d.isSegment(r1-dc, c1-dr) {
+			return false
+		}
+	}
+	if dr == 0 {
+		return d.count(r, 0, 0, 1, FieldWidth, Segment)+unknown <= d.Rows[r]
+	}
+	return d.count(0, c, 1, 0, FieldHeight, Segment)+unknown <= d.Cols[c]
+}
+
+// maxLengthRule caps runs of segments that are as long as the longest ship
+// remaining: the cells beyond both ends must be water.
+func (d *Deduction) maxLengthRule() (progress bool) {
+	remaining := d.remainingShips()
+	if len(remaining) == 0 {
+		return
+	}
+	longest := remaining[0]
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			for dir := 0; dir < 2; dir++ {
+				dr, dc := dir, 1-dir
+				if d.Grid[r][c] != Segment || d.isSegment(r-dr, c-dc) {
+					continue
+				}
+				length := 1
+				for d.isSegment(r+length*dr, c+length*dc) {
+					length++
+				}
+				if length == longest && longest > 1 {
+					reason := "a run of " + strconv.Itoa(length) + " from " + FormatCoords(r, c) + " is as long as the longest remaining ship"
+					if inField(r-dr, c-dc) {
+						progress = d.set("max-length", r-dr, c-dc, Water, reason) || progress
+					}
+					if inField(r+length*dr, c+length*dc) {
+						progress = d.set("max-length", r+length*dr, c+length*dc, Water, reason) || progress
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// unreachableRule marks unknown cells that no remaining ship can cover as water.
+func (d *Deduction) unreachableRule() (progress bool) {
+	remaining := d.remainingShips()
+	var covered Field
+	for _, length := range (remaining) {
+		for r := 0; r < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				for dir := 0; dir < 2; dir++ {
+					if d.fits(r, c, dir, 1-dir, length) {
+						for i := 0; i < length; i++ {
+							covered[r+i*dir][c+i*(1-dir)] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if !covered[r][c] {
+				progress = d.set("unreachable", r, c, Water, "no remaining ship fits here") || progress
+			}
+		}
+	}
+	return
+}
+
+// largestRule considers the largest remaining ship, if there is only one of
+// that length: cells covered by every possible placement must be ship segments.
+func (d *Deduction) largestRule() (progress bool) {
+	remaining := d.remainingShips()
+	if len(remaining) == 0 || len(remaining) > 1 && remaining[1] == remaining[0] {
+		return
+	}
+	length := remaining[0]
+	var common [FieldHeight][FieldWidth]int
+	placements := 0
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			for dir := 0; dir < 2; dir++ {
+				if d.fits(r, c, dir, 1-dir, length) {
+					placements++
+					for i := 0; i < length; i++ {
+						common[r+i*dir][c+i*(1-dir)]++
+					}
+				}
+			}
+		}
+	}
+	if placements == 0 {
+		return
+	}
+	reason := "the ship of length " + strconv.Itoa(length) + " must cover this cell in all " + strconv.Itoa(placements) + " places it fits"
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if common[r][c] == placements {
+				progress = d.set("largest", r, c, Segment, reason) || progress
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,206 @@
+package main
+
+// A line-based engine protocol on standard input and output, so the player can
+// be driven by scripts and other programs without going through HTTP. Each
+// line holds a command followed by its arguments, separated by spaces:
+//
+//   rules                report the rules: "rules <height> <width> <ships>
+//                        <adjacency>", where adjacency is none, diagonal or any
+//   engine <name>        select the engine to play with
+//   newgame              forget the opponent's counts and the shots fired
+//   setup                generate a set-up: "setup <ships>"
+//   counts <rows> <cols> set the opponent's row and column counts
+//   shot <shot>          record the result of a shot, e.g. "shot SA1"
+//   shots <shots>        replace the list of shots, e.g. "shots SA1.WB2"
+//   go [<n>]             request the next move: "move <coords>", preceded by
+//                        "info time <seconds> found <solutions>" lines that
+//                        report progress while searching;
+//                        in the salvo variant, "go <n>" requests a volley of n
+//                        shots: "move <coords> <coords> ..."
+//   isready              check if the engine is alive: "readyok"
+//   quit                 exit
+//
+// Malformed commands are answered with "error <message>".
+
+import (
+	"./game"
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"rand"
+	"strconv"
+	"time"
+)
+
+// infoInterval is the interval between info lines while searching (in ns)
+const infoInterval = 1e9
+
+// engineState holds the state of the current game.
+type engineState struct {
+	engine    *game.Engine
+	rng       *rand.Rand
+	rows      *game.RowCounts
+	cols      *game.ColCounts
+	shots     []game.Shot
+	shotCount int
+}
+
+// addShots appends shots to the list of shots fired so far.
+func (es *engineState) addShots(shots []game.Shot) {
+	if es.shotCount+len(shots) > cap(es.shots) {
+		tmp := make([]game.Shot, es.shotCount, 2*(es.shotCount+len(shots)))
+		copy(tmp, es.shots)
+		es.shots = tmp
+	}
+	es.shots = es.shots[0 : es.shotCount+len(shots)]
+	copy(es.shots[es.shotCount:], shots)
+	es.shotCount += len(shots)
+}
+
+// move determines the next move, or the next volley of n shots if n > 0,
+// reporting progress while searching.
+func (es *engineState) move(n int) {
+	rows, cols, shots := *es.rows, *es.cols, es.shots[0:es.shotCount]
+	done := make(chan [][2]int)
+	go func() {
+		if n > 0 {
+			done <- es.engine.FireSalvo(es.rng, rows, cols, shots, n)
+		} else {
+			r, c := es.engine.Shoot(es.rng, rows, cols, shots)
+			done <- [][2]int{[2]int{r, c}}
+		}
+	}()
+	nsBegin := time.Nanoseconds()
+	ticker := time.NewTicker(infoInterval)
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Printf("info time %.3f", float64(time.Nanoseconds()-nsBegin)/1e9)
+			if n := game.SearchProgress(rows, cols); n >= 0 {
+				fmt.Printf(" found %d", n)
+			}
+			fmt.Println()
+		case volley := <-done:
+			ticker.Stop()
+			fmt.Printf("info time %.3f", float64(time.Nanoseconds()-nsBegin)/1e9)
+			if n := game.CountCandidates(rows, cols, shots); n >= 0 {
+				fmt.Printf(" solutions %d", n)
+			}
+			fmt.Println()
+			fmt.Print("move")
+			for _, cell := range (volley) {
+				fmt.Print(" ", game.FormatCoords(cell[0], cell[1]))
+			}
+			fmt.Println()
+			return
+		}
+	}
+}
+
+// execute executes a single command, and returns false if the engine should quit.
+func (es *engineState) execute(args []string) bool {
+	switch args[0] {
+	case "quit":
+		return false
+	case "isready":
+		fmt.Println("readyok")
+	case "rules":
+		fmt.Println("rules", game.FieldHeight, game.FieldWidth, game.FormatFleet(game.GameRules.Fleet),
+			game.AdjacencyName(game.GameRules.Adjacency))
+	case "engine":
+		if len(args) != 2 {
+			fmt.Println("error engine requires a name")
+		} else if engine := game.LookupEngine(args[1]); engine == nil {
+			fmt.Println("error unknown engine", args[1])
+		} else {
+			es.engine = engine
+		}
+	case "newgame":
+		es.rows, es.cols, es.shotCount = nil, nil, 0
+	case "setup":
+		fmt.Println("setup", game.FormatShips(es.engine.Setup(es.rng)))
+	case "counts":
+		if len(args) != 3 {
+			fmt.Println("error counts requires rows and columns")
+		} else if rows := game.ParseRows(args[1]); rows == nil {
+			fmt.Println("error invalid row count data")
+		} else if cols := game.ParseCols(args[2]); cols == nil {
+			fmt.Println("error invalid column count data")
+		} else {
+			es.rows, es.cols = rows, cols
+		}
+	case "shot", "shots":
+		if len(args) != 2 {
+			fmt.Println("error", args[0], "requires shot data")
+		} else if shots := game.ParseShots(args[1]); shots == nil {
+			fmt.Println("error invalid shot data")
+		} else {
+			if args[0] == "shots" {
+				es.shotCount = 0
+			}
+			es.addShots(shots)
+		}
+	case "go":
+		if es.rows == nil || es.cols == nil {
+			fmt.Println("error no counts given")
+		} else if len(args) == 1 {
+			es.move(0)
+		} else if n, err := strconv.Atoi(args[1]); len(args) != 2 || err != nil || n < 1 {
+			fmt.Println("error invalid salvo size")
+		} else {
+			es.move(n)
+		}
+	default:
+		fmt.Println("error unknown command", args[0])
+	}
+	return true
+}
+
+func main() {
+	// Parse command line arguments:
+	engineName := flag.String("e", "default", "engine to play with")
+	seed := flag.Int64("s", 0, "random seed (0 to pick at random)")
+	flag.FloatVar(&game.TimeOut, "t", game.TimeOut, "move timeout")
+	templates := flag.String("T", "", "file with set-up templates")
+	mixture := flag.String("M", "", "file with a mixed set-up strategy")
+	rules := game.RegisterRuleFlags(false)
+	flag.Parse()
+	if err := rules.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *templates != "" {
+		if err := game.LoadTemplates(*templates); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load templates:", err)
+			os.Exit(1)
+		}
+	}
+	if *mixture != "" {
+		if err := game.LoadMixture(*mixture); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load mixture:", err)
+			os.Exit(1)
+		}
+	}
+
+	es := &engineState{engine: game.LookupEngine(*engineName)}
+	if es.engine == nil {
+		fmt.Fprintln(os.Stderr, "Unknown engine:", *engineName)
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Nanoseconds()
+	}
+	es.rng = rand.New(rand.NewSource(*seed))
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, err := in.ReadString('\n')
+		if args := game.SplitFields(line); len(args) > 0 && !es.execute(args) {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+}
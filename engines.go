@@ -0,0 +1,229 @@
+package game
+
+// Baseline engines, used as reference opponents when benchmarking.
+
+import (
+	"rand"
+	"strings"
+)
+
+// Setups lists the setup policies that can be selected by name.
+var Setups = map[string]SetupFunc{
+	"template":    setup,
+	"adversarial": adversarialSetup,
+	"mixed":       mixedSetup,
+	"random":      RandomSetup,
+}
+
+// Shooters lists the shooting strategies that can be selected by name.
+var Shooters = map[string]ShootFunc{
+	"solver": shoot,
+	"simple": simpleShoot,
+	"hunt":   huntShoot,
+	"random": randomShoot,
+}
+
+// Salvos lists the volley strategies of the shooting strategies that have one.
+var Salvos = map[string]SalvoFunc{
+	"solver": shootSalvo,
+}
+
+// Engines lists the predefined engines that can be selected by name.
+var Engines = map[string]*Engine{
+	"default":     &Engine{"default", setup, shoot, shootSalvo},
+	"adversarial": &Engine{"adversarial", adversarialSetup, shoot, shootSalvo},
+	"mixed":       &Engine{"mixed", mixedSetup, shoot, shootSalvo},
+	"simple":      &Engine{"simple", setup, simpleShoot, nil},
+	"hunt":        &Engine{"hunt", RandomSetup, huntShoot, nil},
+	"random":      &Engine{"random", RandomSetup, randomShoot, nil},
+}
+
+// Ladder lists the names of the predefined engines from weakest to strongest.
+// New engines are benchmarked by playing against each of them in turn.
+var Ladder = []string{"random", "hunt", "simple", "default"}
+
+// LookupEngine returns the engine with the given name, or nil if there is no
+// such engine. Besides the predefined engine names, a name of the form
+// "setup/shooter" combines a setup policy with a shooting strategy.
+func LookupEngine(name string) *Engine {
+	if engine, ok := Engines[name]; ok {
+		return engine
+	}
+	parts := strings.Split(name, "/", 0)
+	if len(parts) != 2 {
+		return nil
+	}
+	setup, ok := Setups[parts[0]]
+	if !ok {
+		return nil
+	}
+	shoot, ok := Shooters[parts[1]]
+	if !ok {
+		return nil
+	}
+	return &Engine{name, setup, shoot, Salvos[parts[1]]}
+}
+
+// maxPlacementAttempts is the number of random locations RandomField tries for
+// a ship, before concluding that the ships placed so far leave no room for it.
+const maxPlacementAttempts = 1000
+
+// maxFieldAttempts is the number of times fleetFits tries to place the fleet.
+const maxFieldAttempts = 100
+
+// RandomField generates a random field by placing each ship at a random
+// location, such that no ships are placed on land or touch each other (as far
+// as the rules forbid). If a ship can't be placed, the field is started over,
+// so the rules must leave room for the fleet (see fleetFits).
+func RandomField(rng *rand.Rand) *Field { return randomField(rng, -1) }
+
+// fleetFits returns whether the fleet can be placed on the board, by trying to
+// generate random fields a limited number of times. This is checked when the
+// rules are set, so that RandomField doesn't get stuck later on.
+func fleetFits() bool { return randomField(newRand(), maxFieldAttempts) != nil }
+
+// randomField implements RandomField, starting the field over at most the
+// given number of times (or indefinitely, if negative), after which it gives
+// up and returns nil.
+func randomField(rng *rand.Rand, restarts int) *Field {
+restart:
+	for attempt := 0; restarts < 0 || attempt <= restarts; attempt++ {
+		var field Field
+		var blocked [FieldHeight][FieldWidth]int
+		for r := 0; r < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				if GameRules.Land[r][c] {
+					blocked[r][c]++
+				}
+			}
+		}
+		for _, shape := range (GameRules.Fleet) {
+			placed := false
+		retry:
+			for attempt := 0; attempt < maxPlacementAttempts && !placed; attempt++ {
+				p := Ship{shape, rng.Intn(len(shape.Orientations)), rng.Intn(FieldHeight), rng.Intn(FieldWidth)}
+				cells := p.Cells()
+				for _, cell := range (cells) {
+					if !inField(cell[0], cell[1]) || blocked[cell[0]][cell[1]] > 0 {
+						continue retry
+					}
+				}
+				// Place ship
+				for _, cell := range (cells) {
+					field[cell[0]][cell[1]] = true
+				}
+				blockShip(&blocked, p, 1)
+				placed = true
+			}
+			if !placed {
+				continue restart
+			}
+		}
+		return &field
+	}
+	return nil
+}
+
+// RandomSetup is a setup policy that places ships at random.
+func RandomSetup(rng *rand.Rand) *Field { return RandomField(rng) }
+
+// randomShoot fires at a random cell that hasn't been fired at before (and
+// isn't land).
+func randomShoot(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
+	fired := GameRules.Land // land can be skipped, just like cells fired at
+	for _, s := range (shots) {
+		fired[s.R][s.C] = true
+	}
+	var count int
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if !fired[r][c] {
+				count++
+				if rng.Intn(count) == 0 {
+					shootR, shootC = r, c
+				}
+			}
+		}
+	}
+	return
+}
+
+// huntShoot implements the classic hunt-and-target strategy, which ignores the
+// row and column counts. While hunting, it fires at random cells on a
+// checkerboard pattern (every ship covers at least one of them). After a hit,
+// it targets the cells next to it, preferring cells in line with other hits,
+// and skipping cells diagonal to a hit, which cannot contain a ship.
+func huntShoot(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
+	fired, hit := GameRules.Land, Field{}
+	for _, s := range (shots) {
+		fired[s.R][s.C] = true
+		hit[s.R][s.C] = s.Hit
+	}
+	var maxScore, count int
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if fired[r][c] || diagonalToHit(&hit, r, c) {
+				continue
+			}
+			score := targetScore(&hit, r, c)
+			if score == 0 && (r+c)%2 == 0 {
+				score = 1 // hunting
+			}
+			if score > maxScore {
+				maxScore = score
+				count = 0
+			}
+			if score == maxScore {
+				count++
+				if rng.Intn(count) == 0 {
+					shootR, shootC = r, c
+				}
+			}
+		}
+	}
+	if count == 0 {
+		// Only cells diagonal to hits are left; fire at any of them:
+		return randomShoot(rng, rows, cols, shots)
+	}
+	return
+}
+
+// diagonalToHit returns whether cell r,c is diagonally adjacent to a hit, and
+// therefore can't contain a ship under the rule that ships may not touch. This
+// only holds for straight ships: the cells of a polyomino may be diagonally
+// adjacent to each other.
+func diagonalToHit(hit *Field, r, c int) bool {
+	if GameRules.Adjacency != NoTouch || !straightFleet() {
+		return false
+	}
+	for dr := -1; dr <= 1; dr += 2 {
+		for dc := -1; dc <= 1; dc += 2 {
+			if inField(r+dr, c+dc) && hit[r+dr][c+dc] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targetScore returns a score for firing at cell r,c based on adjacent hits:
+// 2 points for each orthogonally adjacent hit, plus 1 if that hit is in line
+// with another hit beyond it.
+func targetScore(hit *Field, r, c int) (score int) {
+	for _, d := range (directions) {
+		r1, c1 := r+d[0], c+d[1]
+		if inField(r1, c1) && hit[r1][c1] {
+			score += 2
+			if r2, c2 := r1+d[0], c1+d[1]; inField(r2, c2) && hit[r2][c2] {
+				score++
+			}
+		}
+	}
+	return
+}
+
+// directions are the offsets from a cell to its neighbours along its sides.
+var directions = [4][2]int{[2]int{-1, 0}, [2]int{1, 0}, [2]int{0, -1}, [2]int{0, 1}}
+
+// inField returns whether r,c are valid field coordinates.
+func inField(r, c int) bool { return r >= 0 && r < FieldHeight && c >= 0 && c < FieldWidth }
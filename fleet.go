@@ -0,0 +1,386 @@
+package game
+
+// Ship shapes and fleets. By default the fleet consists of straight ships with
+// the lengths in ShipLengths, but the rules may specify a fleet that includes
+// polyominoes, which can be placed in any rotation or reflection.
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Shape is a polyomino: a set of cells that are connected along their sides.
+// Straight ships are named by their length; other shapes by a single letter.
+type Shape struct {
+	Name         string
+	Orientations []*Orientation // the distinct rotations and reflections
+}
+
+// An Orientation describes a shape in a single rotation or reflection. Cells
+// are given as offsets from the anchor, which is the first cell in row-major
+// order, so that distinct placements of a shape have distinct anchors.
+type Orientation struct {
+	Cells  [][2]int              // row and column offsets of the cells
+	Givens []Given               // the shape of each cell, as revealed by a hint
+	Rows   []int                 // the number of cells in each row, from the anchor's row down
+	Cols   []int                 // the number of cells in each column, from the leftmost column
+	Left   int                   // column offset of the leftmost column (at most 0)
+	Run    int                   // number of cells in the anchor's row, from the anchor rightwards
+	Halo   [Adjacencies][][2]int // offsets of cells blocked for other ships, per rule
+}
+
+// Size returns the number of cells of the shape.
+func (s *Shape) Size() int { return len(s.Orientations[0].Cells) }
+
+// Straight returns whether the shape is a straight ship.
+func (s *Shape) Straight() bool { return s.Name[0] >= '0' && s.Name[0] <= '9' }
+
+// abs returns the absolute value of x.
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// touching returns whether two cells at the given offset from each other may
+// not belong to different ships, according to the given adjacency rule.
+func touching(adjacency, dr, dc int) bool {
+	switch adjacency {
+	case NoTouch:
+		return abs(dr) <= 1 && abs(dc) <= 1
+	case DiagonalTouch:
+		return abs(dr)+abs(dc) <= 1
+	}
+	return dr == 0 && dc == 0
+}
+
+// cellKey encodes the offset of a cell as a map key.
+func cellKey(dr, dc int) int { return (dr+2*FieldHeight)*4*FieldWidth + dc + 2*FieldWidth }
+
+// newOrientation computes the orientation of a shape consisting of the given
+// cells, which must be sorted in row-major order.
+func newOrientation(cells [][2]int) *Orientation {
+	o := &Orientation{Cells: make([][2]int, len(cells)), Givens: make([]Given, len(cells))}
+	occupied := make(map[int]bool)
+	bottom, right := 0, 0
+	for i, cell := range (cells) {
+		o.Cells[i] = [2]int{cell[0] - cells[0][0], cell[1] - cells[0][1]}
+		occupied[cellKey(o.Cells[i][0], o.Cells[i][1])] = true
+	}
+	for _, cell := range (o.Cells) {
+		if cell[1] < o.Left {
+			o.Left = cell[1]
+		}
+		if cell[0] > bottom {
+			bottom = cell[0]
+		}
+		if cell[1] > right {
+			right = cell[1]
+		}
+	}
+	o.Rows = make([]int, bottom+1)
+	o.Cols = make([]int, right-o.Left+1)
+	for i, cell := range (o.Cells) {
+		r, c := cell[0], cell[1]
+		o.Rows[r]++
+		o.Cols[c-o.Left]++
+		o.Givens[i] = cellGiven(occupied[cellKey(r-1, c)], occupied[cellKey(r+1, c)],
+			occupied[cellKey(r, c-1)], occupied[cellKey(r, c+1)])
+	}
+	for occupied[cellKey(0, o.Run)] {
+		o.Run++
+	}
+	for adjacency := 0; adjacency < Adjacencies; adjacency++ {
+		halo := make([][2]int, 0, 9*len(o.Cells))
+		blocked := make(map[int]bool)
+		for _, cell := range (o.Cells) {
+			for dr := -1; dr <= 1; dr++ {
+				for dc := -1; dc <= 1; dc++ {
+					r, c := cell[0]+dr, cell[1]+dc
+					if touching(adjacency, dr, dc) && !blocked[cellKey(r, c)] {
+						blocked[cellKey(r, c)] = true
+						halo = halo[0 : len(halo)+1]
+						halo[len(halo)-1] = [2]int{r, c}
+					}
+				}
+			}
+		}
+		o.Halo[adjacency] = halo
+	}
+	return o
+}
+
+// sortCells sorts cells in row-major order.
+func sortCells(cells [][2]int) {
+	for i := 1; i < len(cells); i++ {
+		for j := i; j > 0 && (cells[j][0] < cells[j-1][0] ||
+			cells[j][0] == cells[j-1][0] && cells[j][1] < cells[j-1][1]); j-- {
+			cells[j], cells[j-1] = cells[j-1], cells[j]
+		}
+	}
+}
+
+// NewShape returns the shape consisting of the given cells. Its orientations
+// are ordered by the transform that produces them (see Transform), so the
+// first orientation of a straight ship is horizontal, and the second vertical.
+func NewShape(name string, cells [][2]int) *Shape {
+	shape := &Shape{Name: name}
+	seen := make(map[string]bool)
+	for t := Transform(0); t < Transforms; t++ {
+		transformed := make([][2]int, len(cells))
+		for i, cell := range (cells) {
+			r, c := cell[0], cell[1]
+			if t&4 != 0 {
+				r, c = c, r
+			}
+			if t&1 != 0 {
+				r = -r
+			}
+			if t&2 != 0 {
+				c = -c
+			}
+			transformed[i] = [2]int{r, c}
+		}
+		sortCells(transformed)
+		o := newOrientation(transformed)
+		key := ""
+		for _, cell := range (o.Cells) {
+			key += strconv.Itoa(cell[0]) + "," + strconv.Itoa(cell[1]) + ";"
+		}
+		if !seen[key] {
+			seen[key] = true
+			tmp := make([]*Orientation, len(shape.Orientations)+1)
+			copy(tmp, shape.Orientations)
+			tmp[len(shape.Orientations)] = o
+			shape.Orientations = tmp
+		}
+	}
+	return shape
+}
+
+// StraightShape returns the shape of a straight ship of the given length.
+func StraightShape(length int) *Shape {
+	cells := make([][2]int, length)
+	for i := range (cells) {
+		cells[i] = [2]int{0, i}
+	}
+	return NewShape(strconv.Itoa(length), cells)
+}
+
+// Polyominoes lists the shapes (other than straight ships) that can be used
+// in a fleet, by name.
+var Polyominoes = map[string]*Shape{
+	"V": NewShape("V", [][2]int{[2]int{0, 0}, [2]int{1, 0}, [2]int{1, 1}}),
+	"L": NewShape("L", [][2]int{[2]int{0, 0}, [2]int{1, 0}, [2]int{2, 0}, [2]int{2, 1}}),
+	"T": NewShape("T", [][2]int{[2]int{0, 0}, [2]int{0, 1}, [2]int{0, 2}, [2]int{1, 1}}),
+	"S": NewShape("S", [][2]int{[2]int{0, 1}, [2]int{0, 2}, [2]int{1, 0}, [2]int{1, 1}}),
+	"O": NewShape("O", [][2]int{[2]int{0, 0}, [2]int{0, 1}, [2]int{1, 0}, [2]int{1, 1}}),
+	"P": NewShape("P", [][2]int{[2]int{0, 0}, [2]int{0, 1}, [2]int{1, 0}, [2]int{1, 1}, [2]int{2, 0}}),
+}
+
+// ParseFleet parses a fleet description: a list of shapes separated by dots,
+// where straight ships are given by their length, and other shapes by their
+// name (see Polyominoes). For example: "5.4.L.T.3.3.2.2". The ships are sorted
+// by decreasing size, as required by the solver.
+func ParseFleet(desc string) []*Shape {
+	parts := strings.Split(desc, ".", 0)
+	fleet := make([]*Shape, len(parts))
+	straight := make(map[int]*Shape)
+	for i, part := range (parts) {
+		if length, err := strconv.Atoi(part); err == nil {
+			if length < 1 || length > FieldHeight && length > FieldWidth {
+				return nil
+			}
+			if straight[length] == nil {
+				straight[length] = StraightShape(length)
+			}
+			fleet[i] = straight[length]
+		} else if fleet[i] = Polyominoes[part]; fleet[i] == nil {
+			return nil
+		}
+	}
+	// Sort by decreasing size, keeping identical shapes together:
+	for i := 1; i < len(fleet); i++ {
+		for j := i; j > 0 && (fleet[j].Size() > fleet[j-1].Size() ||
+			fleet[j].Size() == fleet[j-1].Size() && fleet[j].Name < fleet[j-1].Name); j-- {
+			fleet[j], fleet[j-1] = fleet[j-1], fleet[j]
+		}
+	}
+	return fleet
+}
+
+// DefaultFleet returns the standard fleet of straight ships, with the lengths
+// in ShipLengths.
+func DefaultFleet() []*Shape {
+	names := make([]string, len(ShipLengths))
+	for i, length := range (ShipLengths) {
+		names[i] = strconv.Itoa(length)
+	}
+	return ParseFleet(strings.Join(names, "."))
+}
+
+// FormatFleet returns the description of a fleet, as parsed by ParseFleet.
+func FormatFleet(fleet []*Shape) string {
+	names := make([]string, len(fleet))
+	for i, shape := range (fleet) {
+		names[i] = shape.Name
+	}
+	return strings.Join(names, ".")
+}
+
+// SetFleet parses a fleet description, and sets the fleet of the rules.
+func SetFleet(desc string) os.Error {
+	fleet := ParseFleet(desc)
+	if fleet == nil {
+		return os.NewError("invalid fleet: " + desc)
+	}
+	GameRules.Fleet = fleet
+	return nil
+}
+
+// straightFleet returns whether the fleet consists of straight ships only.
+func straightFleet() bool {
+	for _, shape := range (GameRules.Fleet) {
+		if !shape.Straight() {
+			return false
+		}
+	}
+	return true
+}
+
+// fleetHasSize returns whether the fleet contains a ship of the given size.
+func fleetHasSize(size int) bool {
+	for _, shape := range (GameRules.Fleet) {
+		if shape.Size() == size {
+			return true
+		}
+	}
+	return false
+}
+
+// findShape returns the shape with the given name: a shape in the fleet, a
+// polyomino, or a straight ship.
+func findShape(name string) *Shape {
+	for _, shape := range (GameRules.Fleet) {
+		if shape.Name == name {
+			return shape
+		}
+	}
+	if shape, ok := Polyominoes[name]; ok {
+		return shape
+	}
+	if length, err := strconv.Atoi(name); err == nil && length > 0 {
+		return StraightShape(length)
+	}
+	return nil
+}
+
+// A Ship describes a ship placed on the field: its shape (which determines
+// its length), its orientation, and the position of its anchor (for straight
+// ships, the top-left end), which is its origin.
+type Ship struct {
+	Shape       *Shape
+	Orientation int
+	R, C        int // position of the anchor
+}
+
+// Length returns the number of cells of the ship.
+func (s Ship) Length() int { return s.Shape.Size() }
+
+// Cells returns the coordinates of the cells covered by the ship.
+func (s Ship) Cells() [][2]int {
+	o := s.Shape.Orientations[s.Orientation]
+	cells := make([][2]int, len(o.Cells))
+	for i, cell := range (o.Cells) {
+		cells[i] = [2]int{s.R + cell[0], s.C + cell[1]}
+	}
+	return cells
+}
+
+// InField returns whether the ship lies within the field.
+func (s Ship) InField() bool {
+	for _, cell := range (s.Cells()) {
+		if !inField(cell[0], cell[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid returns whether the ship lies within the field, and not on land.
+func (s Ship) Valid() bool {
+	if !s.InField() {
+		return false
+	}
+	for _, cell := range (s.Cells()) {
+		if GameRules.Land[cell[0]][cell[1]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Conflicts returns whether two ships overlap, or touch each other where the
+// rules forbid it.
+func (s Ship) Conflicts(q Ship) bool {
+	qCells := q.Cells()
+	for _, a := range (s.Cells()) {
+		for _, b := range (qCells) {
+			if touching(GameRules.Adjacency, a[0]-b[0], a[1]-b[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String formats a ship, such as "5HA1" for a straight ship of length 5
+// placed horizontally at A1, or "L3B4" for an L-shape in orientation 3 with
+// its anchor at B4.
+func (s Ship) String() string {
+	if s.Shape.Straight() {
+		dir := "H"
+		if s.Orientation == 1 {
+			dir = "V"
+		}
+		return s.Shape.Name + dir + FormatCoords(s.R, s.C)
+	}
+	return s.Shape.Name + strconv.Itoa(s.Orientation) + FormatCoords(s.R, s.C)
+}
+
+// ParseShip parses a ship in the format written by String. The ship must lie
+// within the field (but may be placed on land).
+func ParseShip(desc string) (s Ship, ok bool) {
+	pos := 0
+	for pos < len(desc) && desc[pos] >= '0' && desc[pos] <= '9' {
+		pos++
+	}
+	if pos > 0 {
+		// A straight ship:
+		if pos+1 >= len(desc) || desc[pos] != 'H' && desc[pos] != 'V' {
+			return
+		}
+		if s.Shape = findShape(desc[0:pos]); s.Shape == nil {
+			return
+		}
+		if desc[pos] == 'V' && len(s.Shape.Orientations) > 1 {
+			s.Orientation = 1
+		}
+	} else {
+		// A polyomino, followed by the orientation:
+		if len(desc) < 3 || desc[1] < '0' || desc[1] > '9' {
+			return
+		}
+		s.Shape = findShape(desc[0:1])
+		if s.Shape == nil || s.Shape.Straight() || int(desc[1]-'0') >= len(s.Shape.Orientations) {
+			return
+		}
+		s.Orientation, pos = int(desc[1]-'0'), 1
+	}
+	if s.R, s.C, ok = ParseCoords(desc[pos+1:]); !ok {
+		return
+	}
+	return s, s.InField()
+}
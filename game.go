@@ -9,6 +9,7 @@ type ColCounts [FieldWidth]int
 type Shot struct {
 	R, C int
 	Hit  bool
+	Sunk int // size of the ship sunk by this shot, or 0 if none (or unknown)
 }
 
 var ShipLengths = [10]int{5, 4, 4, 3, 3, 3, 2, 2, 2, 2}
@@ -26,14 +27,17 @@ func CountShips(field *Field) (rows RowCounts, cols ColCounts) {
 	return
 }
 
-// formats a field as a string (useful for debug printing)
+// formats a field as a string (useful for debug printing), with '#' for ship
+// segments, 'X' for land and '.' for water. See ParseField.
 func (field *Field) String() string {
 	result := ""
-	for _, row := range (*field) {
+	for r, row := range (*field) {
 		line := ""
-		for _, cell := range (row) {
+		for c, cell := range (row) {
 			if cell {
 				line += "#"
+			} else if GameRules.Land[r][c] {
+				line += "X"
 			} else {
 				line += "."
 			}
@@ -1,87 +1,243 @@
 package main
 
-// A tool to generate random fields.
+// A tool to generate random fields with many solutions, which can be used as
+// set-up templates. Fields are either sampled at random, or improved by a local
+// search that moves one ship at a time.
 
 import (
 	"./game"
-	"./util"
+	"./records"
+	"flag"
 	"fmt"
 	"malloc"
+	"math"
+	"os"
 	"rand"
 	"runtime"
 	"time"
 )
 
-const concurrency = 12
-const minDifficulty = 40000
+// Generation parameters, set from the command line:
+var (
+	minSolutions = flag.Int("min", 40000, "minimum number of solutions")
+	maxSolutions = flag.Int("max", 0, "maximum number of solutions (0 for no limit)")
+	search       = flag.String("search", "none", "local search mode: none, hill or anneal")
+	objective    = flag.String("objective", "solutions", "search objective: solutions or expected")
+	steps        = flag.Int("steps", 200, "number of moves per local search")
+	temperature  = flag.Float64("temp", 1, "initial temperature for simulated annealing")
+	where        = flag.String("where", "", "conditions on metrics, e.g. \"expected>=60,depth>70\"")
+)
 
-// Generates a random field by placing each ship at a random location.
-func GenerateField(rng *rand.Rand) (field game.Field) {
-	var blocked [game.FieldHeight][game.FieldWidth]bool
-	for ship := 0; ship < len(game.ShipLengths); ship++ {
-		length := game.ShipLengths[ship]
-	retry:
-		for {
-			r1 := rng.Intn(game.FieldHeight)
-			c1 := rng.Intn(game.FieldWidth)
-			dir := rng.Intn(2)
-			r2 := r1 + (length-1)*dir + 1
-			c2 := c1 + (length-1)*(1-dir) + 1
-			if r2 > game.FieldHeight || c2 > game.FieldWidth {
-				continue
-			}
-			for r := r1; r < r2; r++ {
-				for c := c1; c < c2; c++ {
-					if blocked[r][c] {
-						continue retry
-					}
-				}
-			}
-			// Place ship
-			for r := r1; r < r2; r++ {
-				for c := c1; c < c2; c++ {
-					field[r][c] = true
-				}
-			}
-			// Mark blocked fields
-			br1 := util.Max(0, r1-1)
-			bc1 := util.Max(0, c1-1)
-			br2 := util.Min(game.FieldHeight, r2+1)
-			bc2 := util.Min(game.FieldWidth, c2+1)
-			for r := br1; r < br2; r++ {
-				for c := bc1; c < bc2; c++ {
-					blocked[r][c] = true
-				}
-			}
-			break
-		}
+// conditions are the parsed conditions of the -where flag
+var conditions []records.Condition
+
+// countSolutions counts the solutions for the given row and column counts.
+func countSolutions(rows game.RowCounts, cols game.ColCounts) (count int) {
+	ch := game.GenerateSolutions(rows, cols)
+	for sol := <-ch; sol != nil; sol = <-ch {
+		count++
 	}
 	return
 }
 
-// Continuously generates fields:
-func generate() {
-	rng := rand.New(rand.NewSource(rand.Int63()))
+// accept returns whether a field with the given number of solutions should be
+// output.
+func accept(solutions int) bool {
+	return solutions >= *minSolutions && (*maxSolutions <= 0 || solutions <= *maxSolutions)
+}
+
+// fits returns whether ship i fits on the field without covering land,
+// overlapping or (where the rules forbid it) touching the others.
+func fits(ships []game.Ship, i int) bool {
+	if !ships[i].Valid() {
+		return false
+	}
+	for j, q := range (ships) {
+		if j != i && ships[i].Conflicts(q) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate returns the number of solutions for the given field's counts, and
+// the value of the search objective.
+func evaluate(rng *rand.Rand, field *game.Field) (solutions int, value float64) {
+	rows, cols := game.CountShips(field)
+	if *objective == "expected" {
+		fields := game.ListSolutions(rows, cols)
+		if len(fields) == 0 {
+			return 0, 0
+		}
+		return len(fields), float64(game.GetExpectedScore(game.CreateStrategy(rng, fields)))
+	}
+	solutions = countSolutions(rows, cols)
+	return solutions, math.Log(float64(solutions))
+}
+
+// climb performs a local search starting from the given field, moving one ship
+// at a time to a random new position. In hill climbing mode, only moves that do
+// not decrease the objective are accepted; in annealing mode, worse moves are
+// accepted with a probability that decreases over time. Returns the best field
+// found, and its number of solutions.
+func climb(rng *rand.Rand, field *game.Field) (*game.Field, int) {
+	ships := field.Ships()
+	solutions, value := evaluate(rng, field)
+	best, bestSolutions, bestValue := field, solutions, value
+	for step := 0; step < *steps; step++ {
+		i := rng.Intn(len(ships))
+		old := ships[i]
+		ships[i] = game.Ship{old.Shape, rng.Intn(len(old.Shape.Orientations)),
+			rng.Intn(game.FieldHeight), rng.Intn(game.FieldWidth)}
+		if !fits(ships, i) {
+			ships[i] = old
+			continue
+		}
+		newField := game.FieldFromShips(ships)
+		newSolutions, newValue := evaluate(rng, newField)
+		accepted := newValue >= value
+		if !accepted && *search == "anneal" {
+			t := *temperature * (1 - float64(step)/float64(*steps))
+			accepted = t > 0 && rng.Float64() < math.Exp((newValue-value)/t)
+		}
+		if !accepted {
+			ships[i] = old
+			continue
+		}
+		solutions, value = newSolutions, newValue
+		if value > bestValue {
+			best, bestSolutions, bestValue = newField, solutions, value
+		}
+	}
+	return best, bestSolutions
+}
+
+// Continuously generates fields, and sends the acceptable ones to results:
+func generate(rng *rand.Rand, results chan<- *records.Record) {
+	nsBegin := time.Nanoseconds()
 	for {
-		field := GenerateField(rng)
-		rows, cols := game.CountShips(&field)
-		difficulty := 0
-		ch := game.GenerateSolutions(rows, cols)
-		for sol := <-ch; sol != nil; sol = <-ch {
-			difficulty++
-		}
-		if difficulty >= minDifficulty {
-			fmt.Println(difficulty, game.FormatCounts(&rows), game.FormatCounts(&cols), game.FormatShips(&field))
+		field := game.RandomField(rng)
+		var solutions int
+		if *search == "none" {
+			rows, cols := game.CountShips(field)
+			solutions = countSolutions(rows, cols)
+		} else {
+			field, solutions = climb(rng, field)
+		}
+		if accept(solutions) {
+			rows, cols := game.CountShips(field)
+			r := &records.Record{Rows: rows, Cols: cols, Ships: field, Solutions: solutions}
+			r.Measure(rng)
+			if r.Matches(conditions) {
+				nsEnd := time.Nanoseconds()
+				r.Time = float64(nsEnd-nsBegin) / 1e9
+				results <- r
+				nsBegin = nsEnd
+			}
 			malloc.GC()
 		}
 	}
 }
 
 func main() {
-	runtime.GOMAXPROCS(concurrency)
-	rand.Seed(time.Nanoseconds())
-	for i := 0; i < concurrency; i++ {
-		go generate()
+	// Parse command line arguments:
+	workers := flag.Int("w", 12, "number of concurrent workers")
+	count := flag.Int("n", 0, "number of fields to generate (0 to run forever)")
+	seed := flag.Int64("s", 0, "random seed (0 to pick at random)")
+	output := flag.String("o", "", "output file (default: standard output)")
+	format := flag.String("f", "text", "output format: text or json")
+	sortBy := flag.String("sort", "", "metric to sort by, in decreasing order (requires -n)")
+	rules := game.RegisterRuleFlags(false)
+	flag.Parse()
+
+	if err := rules.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var err os.Error
+	if conditions, err = records.ParseConditions(*where); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *sortBy != "" {
+		if _, ok := (&records.Record{}).Metric(*sortBy); !ok {
+			fmt.Fprintln(os.Stderr, "Unknown metric:", *sortBy)
+			os.Exit(1)
+		}
+		if *count <= 0 {
+			fmt.Fprintln(os.Stderr, "Sorting requires a number of fields (-n)")
+			os.Exit(1)
+		}
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Unknown output format:", *format)
+		os.Exit(1)
+	}
+	if *search != "none" && *search != "hill" && *search != "anneal" {
+		fmt.Fprintln(os.Stderr, "Unknown search mode:", *search)
+		os.Exit(1)
+	}
+	if *objective != "solutions" && *objective != "expected" {
+		fmt.Fprintln(os.Stderr, "Unknown search objective:", *objective)
+		os.Exit(1)
+	}
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Open(*output, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not open output file:", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+	if *seed == 0 {
+		*seed = time.Nanoseconds()
+	}
+
+	// Each worker gets its own random number generator, seeded from the
+	// command line seed, and its own results channel. Results are taken from
+	// the workers in turn, so a run can be reproduced with the same number of
+	// workers (apart from the generation times).
+	runtime.GOMAXPROCS(*workers)
+	results := make([]chan *records.Record, *workers)
+	for i := range (results) {
+		results[i] = make(chan *records.Record, 1)
+		go generate(rand.New(rand.NewSource(*seed+int64(i))), results[i])
+	}
+	turn := 0
+	write := func(r *records.Record) {
+		if *format == "json" {
+			fmt.Fprintln(out, r.JSON())
+		} else {
+			fmt.Fprintln(out, r.Text())
+		}
+	}
+	// Only output one field per signature, up to symmetry:
+	seen := make(map[string]bool)
+	next := func() *records.Record {
+		for {
+			r := <-results[turn]
+			turn = (turn + 1) % len(results)
+			if key := r.Key(); !seen[key] {
+				seen[key] = true
+				return r
+			}
+		}
+		panic("unreachable")
+	}
+	if *sortBy == "" {
+		for i := 0; *count <= 0 || i < *count; i++ {
+			write(next())
+		}
+	} else {
+		batch := make([]*records.Record, *count)
+		for i := range (batch) {
+			batch[i] = next()
+		}
+		records.Sort(batch, *sortBy)
+		for _, r := range (batch) {
+			write(r)
+		}
 	}
-	<-make(chan struct{}) // block forever
 }
@@ -1,13 +1,24 @@
 package game
 
 import (
-	"container/vector"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
+// SplitFields splits a string into its non-empty space-separated fields
+func SplitFields(s string) []string {
+	parts := strings.Split(strings.TrimSpace(s), " ", 0)
+	count := 0
+	for _, part := range (parts) {
+		if part != "" {
+			parts[count] = part
+			count++
+		}
+	}
+	return parts[0:count]
+}
+
 // ParseCoords parses a pair of field coordinates
 func ParseCoords(desc string) (int, int, bool) {
 	c := int(desc[0]) - int('A')
@@ -22,40 +33,202 @@ func ParseCoords(desc string) (int, int, bool) {
 // FormatCoords converts a pair of field coordinates into a string description
 func FormatCoords(r int, c int) string { return string('A'+c) + strconv.Itoa(r+1) }
 
-// ParseShips parses a canonical description of ships into a field array
-func ParseShips(desc string) *Field {
-	var field Field
-	for _, ship := range (strings.Split(desc, ".", 0)) {
-		const pattern = "^[2-5][HV][A-P]([1-9]|1[0-6])$"
-		if matched, _ := regexp.MatchString(pattern, ship); !matched {
+// ParseVolley parses a list of distinct coordinates separated by dots, as
+// fired in a single volley in the salvo variant. An empty string is an empty
+// volley.
+func ParseVolley(desc string) [][2]int {
+	if desc == "" {
+		return make([][2]int, 0)
+	}
+	parts := strings.Split(desc, ".", 0)
+	volley := make([][2]int, len(parts))
+	var fired Field
+	for i, part := range (parts) {
+		if len(part) < 2 {
 			return nil
 		}
-		len := int(ship[0]) - int('0')
-		r1, c1, ok := ParseCoords(ship[2:])
-		if !ok {
+		r, c, ok := ParseCoords(part)
+		if !ok || fired[r][c] {
 			return nil
 		}
-		r2, c2 := r1, c1
-		if ship[1] == 'H' {
-			c2 += len - 1
-		} else {
-			r2 += len - 1
-		}
-		if r2 >= FieldHeight || c2 >= FieldWidth {
+		fired[r][c] = true
+		volley[i] = [2]int{r, c}
+	}
+	return volley
+}
+
+// FormatVolley formats a list of coordinates into the format read by ParseVolley
+func FormatVolley(volley [][2]int) string {
+	parts := make([]string, len(volley))
+	for i, cell := range (volley) {
+		parts[i] = FormatCoords(cell[0], cell[1])
+	}
+	return strings.Join(parts, ".")
+}
+
+// ParseShipList parses a canonical description of ships into a list of
+// ships, which must lie within the field. Whether the ships are placed
+// according to the rules isn't checked here (see ValidateFleet).
+func ParseShipList(desc string) []Ship {
+	parts := strings.Split(desc, ".", 0)
+	ships := make([]Ship, len(parts))
+	for i, part := range (parts) {
+		var ok bool
+		if ships[i], ok = ParseShip(part); !ok {
 			return nil
 		}
-		for r := r1; r <= r2; r++ {
-			for c := c1; c <= c2; c++ {
-				field[r][c] = true
+	}
+	return ships
+}
+
+// ParseShips parses a canonical description of ships into a field array (see
+// ParseShipList). Overlapping ships simply cover the same cells, so ships that
+// break the rules show up as irregular segments when the field is validated.
+func ParseShips(desc string) *Field {
+	ships := ParseShipList(desc)
+	if ships == nil {
+		return nil
+	}
+	return FieldFromShips(ships)
+}
+
+// fleetSearch decomposes a field into placements of the ships in the fleet,
+// for use when ships may touch or aren't straight, so that a ship can't be
+// identified as a run of segments.
+type fleetSearch struct {
+	field   *Field
+	covered Field
+	used    []bool // which ships of the fleet are placed, or nil to allow any number of each shape
+	placed  []Ship
+	sunk    *[FieldHeight][FieldWidth]int // sizes of ships sunk by shots, or nil
+	hit     *Field                        // cells hit by shots, if sunk isn't nil
+}
+
+// free returns whether a ship can be placed at p.
+func (fs *fleetSearch) free(p Ship) bool {
+	for _, cell := range (p.Cells()) {
+		if !inField(cell[0], cell[1]) || !fs.field[cell[0]][cell[1]] || fs.covered[cell[0]][cell[1]] {
+			return false
+		}
+	}
+	for _, q := range (fs.placed) {
+		if p.Conflicts(q) {
+			return false
+		}
+	}
+	return fs.sunk == nil || fs.sunkFits(p)
+}
+
+// sunkFits returns whether a ship at p is consistent with the ships sunk: if it
+// covers the cell where a ship was sunk, it must have the size of that ship,
+// and all of its cells must have been hit (like sunkFits in the solver).
+func (fs *fleetSearch) sunkFits(p Ship) bool {
+	cells := p.Cells()
+	sunk := false
+	for _, cell := range (cells) {
+		if n := fs.sunk[cell[0]][cell[1]]; n > 0 {
+			if n != len(cells) {
+				return false
+			}
+			sunk = true
+		}
+	}
+	if sunk {
+		for _, cell := range (cells) {
+			if !fs.hit[cell[0]][cell[1]] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// search places the remaining ships, returning whether this succeeded. The
+// top-left uncovered segment must be the anchor of one of the remaining ships,
+// so only placements anchored there are tried.
+func (fs *fleetSearch) search() bool {
+	r, c := 0, 0
+	for r < FieldHeight && (!fs.field[r][c] || fs.covered[r][c]) {
+		if c++; c == FieldWidth {
+			r, c = r+1, 0
+		}
+	}
+	fleet := GameRules.Fleet
+	if r == FieldHeight {
+		return fs.used == nil || len(fs.placed) == len(fleet)
+	}
+	for i, shape := range (fleet) {
+		if fs.used == nil {
+			if i > 0 && fleet[i-1] == shape {
+				continue
+			}
+		} else if fs.used[i] || i > 0 && fleet[i-1] == shape && !fs.used[i-1] {
+			continue // try each shape only once
+		}
+		for orientation := range (shape.Orientations) {
+			p := Ship{shape, orientation, r, c}
+			if !fs.free(p) {
+				continue
+			}
+			fs.setCovered(p, true)
+			fs.setUsed(i, true)
+			fs.placed = fs.placed[0 : len(fs.placed)+1]
+			fs.placed[len(fs.placed)-1] = p
+			if fs.search() {
+				return true
 			}
+			fs.placed = fs.placed[0 : len(fs.placed)-1]
+			fs.setUsed(i, false)
+			fs.setCovered(p, false)
+		}
+	}
+	return false
+}
+
+func (fs *fleetSearch) setUsed(i int, value bool) {
+	if fs.used != nil {
+		fs.used[i] = value
+	}
+}
+
+func (fs *fleetSearch) setCovered(p Ship, value bool) {
+	for _, cell := range (p.Cells()) {
+		fs.covered[cell[0]][cell[1]] = value
+	}
+}
+
+// FieldFromShips returns the field containing the given ships.
+func FieldFromShips(ships []Ship) *Field {
+	var field Field
+	for _, ship := range (ships) {
+		for _, cell := range (ship.Cells()) {
+			field[cell[0]][cell[1]] = true
 		}
 	}
 	return &field
 }
 
-// FormatShips encodes a field in a string, as a series of ship placements
-func FormatShips(field *Field) string {
-	var parts vector.StringVector
+// Ships decomposes a field into ships. If the rules allow ships to touch, or
+// the fleet contains polyominoes, the field is decomposed into the ships of the
+// fleet; otherwise (or if that fails) each run of segments is a straight ship.
+// Isolated segments are only ships if the fleet has ships of size 1.
+func (field *Field) Ships() []Ship {
+	if GameRules.Adjacency != NoTouch || !straightFleet() {
+		n := len(GameRules.Fleet)
+		fs := &fleetSearch{field: field, used: make([]bool, n), placed: make([]Ship, 0, n)}
+		if fs.search() {
+			return fs.placed
+		}
+	}
+	ships := make([]Ship, 0, FieldHeight*FieldWidth)
+	add := func(length, orientation, r, c int) {
+		shape := findShape(strconv.Itoa(length))
+		if orientation >= len(shape.Orientations) {
+			orientation = 0 // a ship of length 1 has a single orientation
+		}
+		ships = ships[0 : len(ships)+1]
+		ships[len(ships)-1] = Ship{shape, orientation, r, c}
+	}
 	for r1 := 0; r1 < FieldHeight; r1++ {
 		for c1 := 0; c1 < FieldWidth; c1++ {
 			if field[r1][c1] &&
@@ -70,15 +243,94 @@ func FormatShips(field *Field) string {
 					r2++
 				}
 				if c2-c1 > 1 {
-					parts.Push(strconv.Itoa(c2-c1) + "H" + FormatCoords(r1, c1))
+					add(c2-c1, 0, r1, c1)
 				}
 				if r2-r1 > 1 {
-					parts.Push(strconv.Itoa(r2-r1) + "V" + FormatCoords(r1, c1))
+					add(r2-r1, 1, r1, c1)
+				}
+				if c2-c1 == 1 && r2-r1 == 1 && fleetHasSize(1) {
+					add(1, 0, r1, c1)
 				}
 			}
 		}
 	}
-	return strings.Join(parts.Data(), ".")
+	return ships
+}
+
+// FormatShips encodes a field in a string, as a series of ships (see
+// Field.Ships).
+func FormatShips(field *Field) string { return FormatShipList(field.Ships()) }
+
+// FormatShipList encodes a list of ships in a string, in the format read by
+// ParseShipList.
+func FormatShipList(ships []Ship) string {
+	parts := make([]string, len(ships))
+	for i, ship := range (ships) {
+		parts[i] = ship.String()
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseGrid parses a grid of characters, as written by Field.String, into the
+// cells containing ship segments and land.
+func parseGrid(desc string) (ships, land *Field) {
+	lines := strings.Split(strings.TrimSpace(desc), "\n", 0)
+	if len(lines) != FieldHeight {
+		return nil, nil
+	}
+	ships, land = new(Field), new(Field)
+	for r, line := range (lines) {
+		line = strings.TrimSpace(line)
+		if len(line) != FieldWidth {
+			return nil, nil
+		}
+		for c := 0; c < FieldWidth; c++ {
+			switch line[c] {
+			case '#':
+				ships[r][c] = true
+			case 'X':
+				land[r][c] = true
+			case '.':
+			default:
+				return nil, nil
+			}
+		}
+	}
+	return
+}
+
+// ParseField parses a field in the format written by Field.String. Land cells
+// must match the land of the current rules.
+func ParseField(desc string) *Field {
+	ships, land := parseGrid(desc)
+	if ships == nil {
+		return nil
+	}
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if land[r][c] != GameRules.Land[r][c] {
+				return nil
+			}
+		}
+	}
+	return ships
+}
+
+// ParseBoard parses a board in the format written by Field.String, with 'X'
+// for land cells and '.' elsewhere, and returns the land cells.
+func ParseBoard(desc string) *Field {
+	ships, land := parseGrid(desc)
+	if ships == nil {
+		return nil
+	}
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if ships[r][c] {
+				return nil
+			}
+		}
+	}
+	return land
 }
 
 // ParseRows parses a canonical description of row counts
@@ -124,7 +376,9 @@ func FormatCounts(counts []int) string {
 	return strings.Join(parts, ".")
 }
 
-// ParseShots parses a canonical description of shots
+// ParseShots parses a canonical description of shots. Each shot is a hit
+// ("SA1"), a miss ("WA1"), or a hit that sinks a ship, which is followed by the
+// size of the ship ("K3A1", where a ship of size 3 was sunk).
 func ParseShots(desc string) []Shot {
 	if desc == "" {
 		return make([]Shot, 0)
@@ -140,6 +394,17 @@ func ParseShots(desc string) []Shot {
 			shots[i].Hit = true
 		case 'W':
 			shots[i].Hit = false
+		case 'K':
+			shots[i].Hit = true
+			pos := 1
+			for pos < len(part) && part[pos] >= '0' && part[pos] <= '9' {
+				pos++
+			}
+			size, err := strconv.Atoi(part[1:pos])
+			if err != nil || !fleetHasSize(size) || len(part) < pos+2 {
+				return nil
+			}
+			shots[i].Sunk, part = size, part[pos-1:]
 		default:
 			return nil
 		}
@@ -151,3 +416,18 @@ func ParseShots(desc string) []Shot {
 	}
 	return shots
 }
+
+// FormatShots formats a list of shots into the canonical format read by ParseShots
+func FormatShots(shots []Shot) string {
+	parts := make([]string, len(shots))
+	for i, shot := range (shots) {
+		if shot.Sunk > 0 {
+			parts[i] = "K" + strconv.Itoa(shot.Sunk) + FormatCoords(shot.R, shot.C)
+		} else if shot.Hit {
+			parts[i] = "S" + FormatCoords(shot.R, shot.C)
+		} else {
+			parts[i] = "W" + FormatCoords(shot.R, shot.C)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,178 @@
+package game
+
+// A Game adjudicates a match between two players, who take turns firing single
+// shots at each other's fleet, starting with the first player. The first player
+// to sink the opponent's entire fleet wins. Hits are announced as such, and the
+// shot that sinks a ship also announces the size of that ship (see Shot).
+
+import (
+	"os"
+	"strings"
+)
+
+// A Game holds the fleets of both players, and the shots they fired so far.
+type Game struct {
+	Fleets [2]*Field                       // the fleets of both players
+	Shots  [2][]Shot                       // the shots fired by each player at the opponent's fleet
+	ships  [2][]Ship                       // the ships each fleet consists of, as placed
+	hits   [2][FieldHeight][FieldWidth]int // index+1 of the ship in each cell, or 0
+	afloat [2][]int                        // number of cells not yet hit per ship
+}
+
+// NewGame starts a new game between the given fleets, as placed by the players.
+// Each fleet must consist of the ships of the rules' fleet, placed according to
+// the rules (see ValidateShips). The ships are kept as given, since where ships
+// may touch, a field can't always be divided into ships unambiguously.
+func NewGame(a, b []Ship) (*Game, os.Error) {
+	g := &Game{ships: [2][]Ship{a, b}}
+	for player, ships := range (g.ships) {
+		if violations := ValidateShips(ships); violations != nil {
+			return nil, os.NewError("invalid fleet for player " + string('1'+player) + ": " +
+				strings.Join(violations, "; "))
+		}
+		g.Fleets[player] = FieldFromShips(ships)
+		g.afloat[player] = make([]int, len(ships))
+		for i, ship := range (ships) {
+			for _, cell := range (ship.Cells()) {
+				g.hits[player][cell[0]][cell[1]] = i + 1
+			}
+			g.afloat[player][i] = ship.Shape.Size()
+		}
+		g.Shots[player] = make([]Shot, 0, MaxShots)
+	}
+	return g, nil
+}
+
+// Turn returns the player to move next (0 or 1).
+func (g *Game) Turn() int {
+	if len(g.Shots[0]) > len(g.Shots[1]) {
+		return 1
+	}
+	return 0
+}
+
+// Counts returns the row and column counts of the given player's fleet, which
+// are known to the opponent.
+func (g *Game) Counts(player int) (RowCounts, ColCounts) {
+	return CountShips(g.Fleets[player])
+}
+
+// Afloat returns the number of ships of the given player's fleet that have not
+// been sunk yet.
+func (g *Game) Afloat(player int) (count int) {
+	for _, cells := range (g.afloat[player]) {
+		if cells > 0 {
+			count++
+		}
+	}
+	return
+}
+
+// Over returns whether the game is over, i.e. a fleet has been sunk.
+func (g *Game) Over() bool { return g.Afloat(0) == 0 || g.Afloat(1) == 0 }
+
+// Winner returns the player that won the game, or -1 if the game isn't over.
+func (g *Game) Winner() int {
+	switch {
+	case g.Afloat(1) == 0:
+		return 0
+	case g.Afloat(0) == 0:
+		return 1
+	}
+	return -1
+}
+
+// Fire lets the given player fire at cell r,c of the opponent's fleet, and
+// returns the result. The move is rejected if the game is over, it isn't the
+// player's turn, or the cell is off the field, on land or was fired at before.
+func (g *Game) Fire(player, r, c int) (Shot, os.Error) {
+	switch {
+	case g.Over():
+		return Shot{}, os.NewError("game is over")
+	case player != g.Turn():
+		return Shot{}, os.NewError("not the player's turn")
+	case !inField(r, c):
+		return Shot{}, os.NewError("cell is off the field")
+	case GameRules.Land[r][c]:
+		return Shot{}, os.NewError("cell " + FormatCoords(r, c) + " is on land")
+	}
+	for _, s := range (g.Shots[player]) {
+		if s.R == r && s.C == c {
+			return Shot{}, os.NewError("cell " + FormatCoords(r, c) + " was fired at before")
+		}
+	}
+	opponent := 1 - player
+	shot := Shot{R: r, C: c}
+	if i := g.hits[opponent][r][c] - 1; i >= 0 {
+		shot.Hit = true
+		if g.afloat[opponent][i]--; g.afloat[opponent][i] == 0 {
+			shot.Sunk = g.ships[opponent][i].Shape.Size()
+		}
+	}
+	shots := g.Shots[player]
+	shots = shots[0 : len(shots)+1]
+	shots[len(shots)-1] = shot
+	g.Shots[player] = shots
+	return shot, nil
+}
+
+// String serializes the state of the game, in the format read by ParseGame:
+// the ships of both players (as written by FormatShipList) followed by the
+// shots they fired (as written by FormatShots), one per line.
+func (g *Game) String() string {
+	return "fleet " + FormatShipList(g.ships[0]) + "\n" +
+		"fleet " + FormatShipList(g.ships[1]) + "\n" +
+		"shots " + FormatShots(g.Shots[0]) + "\n" +
+		"shots " + FormatShots(g.Shots[1]) + "\n"
+}
+
+// ParseGame parses the state of a game, as written by Game.String, and replays
+// the shots to check that they are valid and their results are correct.
+func ParseGame(desc string) (*Game, os.Error) {
+	lines := strings.Split(strings.TrimSpace(desc), "\n", 0)
+	if len(lines) != 4 {
+		return nil, os.NewError("game must consist of 4 lines")
+	}
+	var args [4]string
+	for i, line := range (lines) {
+		keyword := "fleet"
+		if i >= 2 {
+			keyword = "shots"
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, keyword) {
+			return nil, os.NewError("line " + string('1'+i) + " must start with " + keyword)
+		}
+		args[i] = strings.TrimSpace(line[len(keyword):])
+	}
+	var fleets [2][]Ship
+	var shots [2][]Shot
+	for player := 0; player < 2; player++ {
+		if fleets[player] = ParseShipList(args[player]); fleets[player] == nil {
+			return nil, os.NewError("invalid ship data: " + args[player])
+		}
+		if shots[player] = ParseShots(args[2+player]); shots[player] == nil {
+			return nil, os.NewError("invalid shot data: " + args[2+player])
+		}
+	}
+	g, err := NewGame(fleets[0], fleets[1])
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(shots[0]) || i < len(shots[1]); i++ {
+		for player := 0; player < 2; player++ {
+			if i >= len(shots[player]) {
+				continue
+			}
+			s := shots[player][i]
+			result, err := g.Fire(player, s.R, s.C)
+			if err != nil {
+				return nil, err
+			}
+			if result.Hit != s.Hit || result.Sunk != s.Sunk {
+				return nil, os.NewError("incorrect result for shot " + FormatShots(shots[player][i:i+1]))
+			}
+		}
+	}
+	return g, nil
+}
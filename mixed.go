@@ -0,0 +1,227 @@
+package game
+
+// Mixed set-up strategies: always picking the hardest field against a fixed
+// shooter can be exploited by an opponent that adapts. Instead, we compute a
+// probability distribution over candidate fields that maximizes the expected
+// number of shots of a best-responding shooter, using fictitious play: both
+// players repeatedly play a best response to the other's empirical mixture.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"rand"
+	"strconv"
+	"strings"
+)
+
+// A Mixture is a probability distribution over set-ups.
+type Mixture struct {
+	Fields  []*Field
+	Weights []float
+}
+
+// SetupMixture is the distribution sampled by the mixed set-up policy.
+var SetupMixture *Mixture
+
+// mixtureEpsilon is the weight given to fields outside the support of the
+// mixture when constructing a best response, so that the resulting strategy
+// covers every candidate field.
+const mixtureEpsilon = 1e-3
+
+// OptimizeMixture computes a mixed strategy over the given candidate fields
+// (which must share the same row and column counts) by running the given
+// number of iterations of fictitious play. The shooter's pure strategies are
+// the reference shooters, and greedy strategies that best respond to the
+// set-up mixture. It returns the mixture and its value: the expected number of
+// shots the best shooter found needs against it.
+func OptimizeMixture(rng *rand.Rand, candidates []*Field, iterations int) (*Mixture, float) {
+	n := len(candidates)
+	if n == 0 || iterations <= 0 {
+		return nil, 0
+	}
+
+	// payoffs[j][i] is the number of shots shooter j needs to sink field i:
+	payoffs := make([][]float, 0, iterations+len(referenceShooters)+1)
+	addShooter := func(shots func(field *Field) float) {
+		row := make([]float, n)
+		for i, field := range (candidates) {
+			row[i] = shots(field)
+		}
+		payoffs = payoffs[0 : len(payoffs)+1]
+		payoffs[len(payoffs)-1] = row
+	}
+	for _, shooter := range (referenceShooters) {
+		shooter := shooter
+		addShooter(func(field *Field) float {
+			var shots int
+			for i := 0; i < AdversarialRuns; i++ {
+				shots += PlayOut(shooter, rng, field)
+			}
+			return float(shots) / float(AdversarialRuns)
+		})
+	}
+	greedy := func(weights []float) {
+		fields := make([]*Field, n)
+		copy(fields, candidates)
+		w := make([]float, n)
+		for i := range (w) {
+			w[i] = weights[i] + mixtureEpsilon
+		}
+		strategy := CreateWeightedStrategy(rng, fields, w)
+		addShooter(func(field *Field) float { return float(GetFieldScore(strategy, field)) })
+	}
+
+	// Play fictitious play, starting from the uniform mixture:
+	setterCounts := make([]float, n)
+	shooterCounts := make([]float, cap(payoffs))
+	for i := range (setterCounts) {
+		setterCounts[i] = 1
+	}
+	for it := 0; it < iterations; it++ {
+		// Shooter: best response to the set-up mixture so far.
+		greedy(setterCounts)
+		best, bestValue := -1, float(0)
+		for j, row := range (payoffs) {
+			var value float
+			for i, count := range (setterCounts) {
+				value += count * row[i]
+			}
+			if best < 0 || value < bestValue {
+				best, bestValue = j, value
+			}
+		}
+		shooterCounts[best]++
+
+		// Setter: best response to the shooter mixture so far.
+		best, bestValue = -1, 0
+		for i := 0; i < n; i++ {
+			var value float
+			for j, row := range (payoffs) {
+				value += shooterCounts[j] * row[i]
+			}
+			if best < 0 || value > bestValue || value == bestValue && rng.Intn(2) == 0 {
+				best, bestValue = i, value
+			}
+		}
+		setterCounts[best]++
+	}
+
+	// Normalize the setter's counts into a mixture, dropping unused fields:
+	var total float
+	var support int
+	for _, count := range (setterCounts) {
+		if count > 1 {
+			total += count - 1
+			support++
+		}
+	}
+	mixture := &Mixture{make([]*Field, 0, support), make([]float, 0, support)}
+	for i, count := range (setterCounts) {
+		if count > 1 {
+			k := len(mixture.Fields)
+			mixture.Fields = mixture.Fields[0 : k+1]
+			mixture.Weights = mixture.Weights[0 : k+1]
+			mixture.Fields[k] = candidates[i]
+			mixture.Weights[k] = (count - 1) / total
+		}
+	}
+
+	// Value of the game: the best shooter's expected score against the mixture.
+	var value float
+	for j, row := range (payoffs) {
+		var v float
+		for i, count := range (setterCounts) {
+			if count > 1 {
+				v += (count - 1) / total * row[i]
+			}
+		}
+		if j == 0 || v < value {
+			value = v
+		}
+	}
+	return mixture, value
+}
+
+// Sample returns a random field from the mixture.
+func (m *Mixture) Sample(rng *rand.Rand) *Field {
+	var total float
+	for _, w := range (m.Weights) {
+		total += w
+	}
+	x := rng.Float() * total
+	for i, w := range (m.Weights) {
+		if x < w {
+			return m.Fields[i]
+		}
+		x -= w
+	}
+	return m.Fields[len(m.Fields)-1]
+}
+
+// Scale multiplies all weights in the mixture by the given factor.
+func (m *Mixture) Scale(factor float) {
+	for i := range (m.Weights) {
+		m.Weights[i] *= factor
+	}
+}
+
+// String formats the mixture with one field per line: a weight followed by
+// the ships, as read by LoadMixture.
+func (m *Mixture) String() string {
+	result := ""
+	for i, field := range (m.Fields) {
+		result += fmt.Sprintf("%g %s\n", m.Weights[i], FormatShips(field))
+	}
+	return result
+}
+
+// LoadMixture replaces the SetupMixture with the fields read from the given
+// file, which contains a weight and a ship description per line (as written by
+// Mixture.String). Empty lines and lines starting with '#' are ignored.
+func LoadMixture(filename string) os.Error {
+	data, err := io.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n", 0)
+	mixture := &Mixture{make([]*Field, 0, len(lines)), make([]float, 0, len(lines))}
+	for i, line := range (lines) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := SplitFields(line)
+		if len(parts) != 2 {
+			return os.NewError(filename + ":" + strconv.Itoa(i+1) + ": invalid mixture entry")
+		}
+		weight, err := strconv.Atof(parts[0])
+		field := ParseShips(parts[1])
+		if err != nil || weight < 0 || field == nil {
+			return os.NewError(filename + ":" + strconv.Itoa(i+1) + ": invalid mixture entry")
+		}
+		if _, violations := ValidateFleet(field); violations != nil {
+			return os.NewError(filename + ":" + strconv.Itoa(i+1) + ": invalid fleet: " +
+				strings.Join(violations, "; "))
+		}
+		k := len(mixture.Fields)
+		mixture.Fields = mixture.Fields[0 : k+1]
+		mixture.Weights = mixture.Weights[0 : k+1]
+		mixture.Fields[k] = field
+		mixture.Weights[k] = weight
+	}
+	if len(mixture.Fields) == 0 {
+		return os.NewError(filename + ": no fields found")
+	}
+	SetupMixture = mixture
+	return nil
+}
+
+// mixedSetup samples a field from the SetupMixture, under a random symmetry
+// of the board. If no mixture was loaded, it falls back to the template pool.
+func mixedSetup(rng *rand.Rand) *Field {
+	if SetupMixture == nil {
+		return setup(rng)
+	}
+	return randomTransform(rng).Field(SetupMixture.Sample(rng))
+}
@@ -0,0 +1,69 @@
+package main
+
+// A tool to compute mixed set-up strategies for a pool of templates, to be
+// loaded by the mixed set-up policy.
+
+import (
+	"./game"
+	"flag"
+	"fmt"
+	"os"
+	"rand"
+	"time"
+)
+
+func main() {
+	// Parse command line arguments:
+	templates := flag.String("T", "", "file with set-up templates (default: built-in template)")
+	candidates := flag.Int("n", 200, "number of candidate fields per template")
+	iterations := flag.Int("i", 200, "number of iterations of fictitious play")
+	output := flag.String("o", "", "output file (default: standard output)")
+	seed := flag.Int64("s", 0, "random seed (0 to pick at random)")
+	flag.Parse()
+
+	if *templates != "" {
+		if err := game.LoadTemplates(*templates); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load templates:", err)
+			os.Exit(1)
+		}
+	}
+	if *seed == 0 {
+		*seed = time.Nanoseconds()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	out := os.Stdout
+	if *output != "" {
+		var err os.Error
+		out, err = os.Open(*output, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not open output file:", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	var totalWeight float
+	for _, t := range (game.Templates) {
+		totalWeight += t.Weight
+	}
+	for _, t := range (game.Templates) {
+		solutions := t.Solutions()
+		n := len(solutions)
+		if n > *candidates {
+			n = *candidates
+		}
+		fields := make([]*game.Field, n)
+		for i, j := range (rng.Perm(len(solutions))[0:n]) {
+			fields[i] = solutions[j]
+		}
+		mixture, value := game.OptimizeMixture(rng, fields, *iterations)
+		if mixture == nil {
+			continue
+		}
+		mixture.Scale(t.Weight / totalWeight)
+		fmt.Fprintf(out, "# %s %s: %d fields, value %.3f\n",
+			game.FormatCounts(&t.Rows), game.FormatCounts(&t.Cols), len(mixture.Fields), value)
+		fmt.Fprint(out, mixture)
+	}
+}
@@ -8,6 +8,8 @@ var TimeOut float = 30
 
 var solutionsCache = make(map[string][]*Field)       // caches known solutions
 var solutionsNotify = make(map[string]chan []*Field) // notifies waiters
+var solutionsFound = make(map[string]int)            // progress of searches running
+var constrainedSearches = make(map[string]bool)      // keys with a search constrained by shots running
 var solutionsCacheMutex sync.Mutex
 
 func getCacheKey(rows RowCounts, cols ColCounts) string {
@@ -15,6 +17,7 @@ func getCacheKey(rows RowCounts, cols ColCounts) string {
 }
 
 func PurgeCache(rows RowCounts, cols ColCounts) {
+	rows, cols, _ = Canonicalize(rows, cols)
 	solutionsCacheMutex.Lock()
 	solutionsCache[getCacheKey(rows, cols)] = nil, false
 	solutionsCacheMutex.Unlock()
@@ -31,11 +34,17 @@ func getSolutions(rows RowCounts, cols ColCounts, maxWaitNs int64) []*Field {
 		if !found {
 			notify = make(chan []*Field)
 			solutionsNotify[key] = notify
+			solutionsFound[key] = 0
 			go func() {
-				solutions := ListSolutions(rows, cols)
+				solutions := collectSolutions(GenerateSolutions(rows, cols), func(found int) {
+					solutionsCacheMutex.Lock()
+					solutionsFound[key] = found
+					solutionsCacheMutex.Unlock()
+				})
 				solutionsCacheMutex.Lock()
 				solutionsCache[key] = solutions
 				solutionsNotify[key] = nil, false
+				solutionsFound[key] = 0, false
 				solutionsCacheMutex.Unlock()
 			notifyWaiters:
 				for {
@@ -61,17 +70,105 @@ func getSolutions(rows RowCounts, cols ColCounts, maxWaitNs int64) []*Field {
 	return solutions
 }
 
-// Setup returns a random new field set-up
-func Setup() *Field {
-	// FIXME: template is hard-coded!
-	// FIXME: I do have harder templates.
-	rows := RowCounts{2, 0, 4, 0, 2, 0, 3, 0, 7, 0, 5, 0, 3, 0, 4, 0}
-	cols := ColCounts{1, 1, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1}
-	solutions := ListSolutions(rows, cols)
-	return solutions[rand.Intn(len(solutions))]
+// SearchProgress returns the number of solutions for the given counts found so
+// far: all of them if the search has finished, or the number found by the
+// search in progress (roughly). If no search was started, -1 is returned.
+func SearchProgress(rows RowCounts, cols ColCounts) int {
+	rows, cols, _ = Canonicalize(rows, cols)
+	key := getCacheKey(rows, cols)
+	solutionsCacheMutex.Lock()
+	defer solutionsCacheMutex.Unlock()
+	if solutions, found := solutionsCache[key]; found {
+		return len(solutions)
+	}
+	if found, searching := solutionsFound[key]; searching {
+		return found
+	}
+	return -1
 }
 
+// findSolutions returns the solutions for the given counts that are consistent
+// with the shots fired, or nil if they can't be found within maxWaitNs
+// nanoseconds. If the solutions for the counts aren't cached yet, a search
+// constrained by the shots (see GenerateSolutionsWithShots), which is usually
+// much faster, is raced against the full search, which keeps running to fill
+// the cache for later shots. Searches can't be cancelled, so only one
+// constrained search runs per key: while it does, later shots just wait for
+// the full search.
+func findSolutions(rows RowCounts, cols ColCounts, shots []Shot, maxWaitNs int64) []*Field {
+	key := getCacheKey(rows, cols)
+	solutionsCacheMutex.Lock()
+	_, cached := solutionsCache[key]
+	race := !cached && len(shots) > 0 && !constrainedSearches[key]
+	if race {
+		constrainedSearches[key] = true
+	}
+	solutionsCacheMutex.Unlock()
+	if !race {
+		solutions := getSolutions(rows, cols, maxWaitNs)
+		if solutions == nil {
+			return nil
+		}
+		return filterShots(solutions, shots)
+	}
+	found := make(chan []*Field, 2)
+	go func() {
+		if solutions := getSolutions(rows, cols, maxWaitNs); solutions != nil {
+			found <- filterShots(solutions, shots)
+		}
+	}()
+	go func() {
+		solutions := ListSolutionsWithShots(rows, cols, shots)
+		solutionsCacheMutex.Lock()
+		constrainedSearches[key] = false, false
+		solutionsCacheMutex.Unlock()
+		found <- solutions
+	}()
+	var solutions []*Field
+	ticker := time.NewTicker(maxWaitNs)
+	select {
+	case solutions = <-found:
+	case <-ticker.C:
+		solutions = nil // timer expired!
+	}
+	ticker.Stop()
+	return solutions
+}
+
+// CountCandidates returns the number of known solutions for the given counts
+// that are consistent with the shots fired, or -1 if no solutions are known.
+func CountCandidates(rows RowCounts, cols ColCounts, shots []Shot) int {
+	rows, cols, t := Canonicalize(rows, cols)
+	shots = t.Shots(shots)
+	solutionsCacheMutex.Lock()
+	solutions, found := solutionsCache[getCacheKey(rows, cols)]
+	solutionsCacheMutex.Unlock()
+	if !found {
+		return -1
+	}
+	return len(filterShots(solutions, shots))
+}
+
+// newRand returns a new random number generator, seeded from the global one.
+func newRand() *rand.Rand { return rand.New(rand.NewSource(rand.Int63())) }
+
+// Setup returns a random new field set-up
+func Setup() *Field { return setup(newRand()) }
+
+func setup(rng *rand.Rand) *Field { return setupFromTemplates(rng, Templates) }
+
+// filterShots returns the solutions that are consistent with the shots fired:
+// hits and misses must match, and each sunk ship must be exactly covered by hits
+// (see sunkMatches and sunkDecomposes).
 func filterShots(solutions []*Field, shots []Shot) []*Field {
+	var hit Field
+	var sizes [FieldHeight][FieldWidth]int
+	sunk := false
+	for _, shot := range (shots) {
+		hit[shot.R][shot.C] = shot.Hit
+		sizes[shot.R][shot.C] = shot.Sunk
+		sunk = sunk || shot.Sunk > 0
+	}
 	count := 0
 	filtered := make([]*Field, len(solutions))
 loop:
@@ -81,38 +178,95 @@ loop:
 				continue loop
 			}
 		}
+		if sunk && GameRules.Adjacency != NoTouch {
+			if !sunkDecomposes(solution, &hit, &sizes) {
+				continue loop
+			}
+		} else if sunk {
+			for _, shot := range (shots) {
+				if shot.Sunk > 0 && !sunkMatches(solution, &hit, shot) {
+					continue loop
+				}
+			}
+		}
 		filtered[count] = solution
 		count++
 	}
 	return filtered[0:count]
 }
 
+// sunkMatches returns whether the ship sunk by the given shot can be found in
+// the field, where ships may not touch: the group of connected segments around
+// the cell fired at must have the sunk size, and all of its cells must have
+// been hit.
+func sunkMatches(field, hit *Field, shot Shot) bool {
+	var seen Field
+	cells := componentAt(field, &seen, shot.R, shot.C)
+	if len(cells) != shot.Sunk {
+		return false
+	}
+	for _, cell := range (cells) {
+		if !hit[cell[0]][cell[1]] {
+			return false
+		}
+	}
+	return true
+}
+
+// sunkDecomposes returns whether the field, where ships may touch, can be
+// divided into the ships of the fleet such that each ship sunk is a ship of
+// the sunk size covering the cell fired at, all of whose cells have been hit.
+// This is the rule the solver applies (see GenerateSolutionsWithShots).
+func sunkDecomposes(field, hit *Field, sunk *[FieldHeight][FieldWidth]int) bool {
+	n := len(GameRules.Fleet)
+	fs := &fleetSearch{field: field, used: make([]bool, n), placed: make([]Ship, 0, n), sunk: sunk, hit: hit}
+	return fs.search()
+}
+
 // SimpleShoot fires at a cell with a maximum probability of hitting, estimating
-// this probability as rows[r] + cols[c]. This algorithm is simplistic, but very
-// fast.
+// this probability as rows[r] + cols[c], where the counts exclude known hits.
+// Cells next to hits are targeted first, to finish off damaged ships. Cells on
+// land are skipped, and so are cells diagonal to hits, unless the rules allow
+// ships to touch. This algorithm is simplistic, but very fast.
 func SimpleShoot(rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
-	var shot Field
+	return simpleShoot(newRand(), rows, cols, shots)
+}
+
+func simpleShoot(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
+	shot, hit := GameRules.Land, Field{} // land cells are never fired at
 	for _, s := range (shots) {
+		if s.Hit && !shot[s.R][s.C] {
+			rows[s.R]--
+			cols[s.C]--
+		}
 		shot[s.R][s.C] = true
+		hit[s.R][s.C] = s.Hit
 	}
 	var maxHit, hitCount int
 	for r := 0; r < FieldHeight; r++ {
 		for c := 0; c < FieldWidth; c++ {
-			if !shot[r][c] && rows[r] > 0 && cols[c] > 0 {
-				hit := rows[r] + cols[c]
-				if hit > maxHit {
-					maxHit = hit
+			if !shot[r][c] && rows[r] > 0 && cols[c] > 0 && !diagonalToHit(&hit, r, c) {
+				score := rows[r] + cols[c]
+				if target := targetScore(&hit, r, c); target > 0 {
+					score += target * (FieldHeight + FieldWidth)
+				}
+				if score > maxHit {
+					maxHit = score
 					hitCount = 0
 				}
-				if hit == maxHit {
+				if score == maxHit {
 					hitCount++
-					if rand.Intn(hitCount) == 0 {
+					if rng.Intn(hitCount) == 0 {
 						shootR, shootC = r, c
 					}
 				}
 			}
 		}
 	}
+	if maxHit == 0 {
+		// Counts are inconsistent with the shots; fire anywhere:
+		return randomShoot(rng, rows, cols, shots)
+	}
 	return
 }
 
@@ -129,19 +283,29 @@ func CountHits(solutions []*Field, r, c int) int {
 
 // Shoot returns the coordinates of an unoccupied cell to fire at
 func Shoot(rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
+	return shoot(newRand(), rows, cols, shots)
+}
+
+func shoot(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
+	// Solve the canonical variant of the board, and map the result back:
+	rows, cols, t := Canonicalize(rows, cols)
+	r, c := shootCanonical(rng, rows, cols, t.Shots(shots))
+	return t.Inverse().Coords(r, c)
+}
+
+func shootCanonical(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
 	// Mark cells we've shot at before
 	var shot Field
 	for _, s := range (shots) {
 		shot[s.R][s.C] = true
 	}
 
-	// Find all solutions:
-	solutions := getSolutions(rows, cols, int64(TimeOut*1e9))
+	// Find all solutions consistent with the shots:
+	solutions := findSolutions(rows, cols, shots, int64(TimeOut*1e9))
 	if solutions == nil {
 		// Solver timed out; use a less sophisticated algorithm:
-		return SimpleShoot(rows, cols, shots)
+		return simpleShoot(rng, rows, cols, shots)
 	}
-	solutions = filterShots(solutions, shots)
 
 	// Count how often each (unfired) cell is hit:
 	var hits [FieldHeight][FieldWidth]int
@@ -175,7 +339,7 @@ func Shoot(rows RowCounts, cols ColCounts, shots []Shot) (shootR, shootC int) {
 			}
 			if hit == max {
 				cnt++
-				if rand.Intn(cnt) == 0 {
+				if rng.Intn(cnt) == 0 {
 					shootR, shootC = r, c
 				}
 			}
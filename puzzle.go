@@ -0,0 +1,300 @@
+package game
+
+// Solitaire puzzles: given the row and column counts of a field, and a few
+// revealed cells, find the positions of all ships. Puzzles are generated such
+// that they have exactly one solution.
+
+import (
+	"fmt"
+	"rand"
+	"strconv"
+	"strings"
+)
+
+// A Given describes what a hint reveals about a cell: either water, or a ship
+// segment, possibly with its shape. Givens are written as single characters.
+type Given byte
+
+const (
+	GivenWater  Given = '~'
+	GivenShip   Given = '#' // a ship segment of unspecified shape
+	GivenSub    Given = 'o' // a ship of length 1
+	GivenLeft   Given = '<' // the left end of a horizontal ship
+	GivenRight  Given = '>' // the right end of a horizontal ship
+	GivenTop    Given = '^' // the top end of a vertical ship
+	GivenBottom Given = 'v' // the bottom end of a vertical ship
+	GivenMiddle Given = '+' // a segment between both ends of a ship
+)
+
+// validGiven returns whether ch is the character of a given.
+func validGiven(ch byte) bool {
+	switch Given(ch) {
+	case GivenWater, GivenShip, GivenSub, GivenLeft, GivenRight, GivenTop, GivenBottom, GivenMiddle:
+		return true
+	}
+	return false
+}
+
+// Ship returns whether the given reveals a ship segment.
+func (g Given) Ship() bool { return g != GivenWater }
+
+// cellGiven returns the given describing a ship segment, given whether the
+// adjacent cells in each direction belong to the same ship. Segments of
+// polyominoes with more than one neighbour are considered middle segments.
+func cellGiven(up, down, left, right bool) Given {
+	switch {
+	case up && (down || left || right), down && (left || right), left && right:
+		return GivenMiddle
+	case right:
+		return GivenLeft
+	case left:
+		return GivenRight
+	case down:
+		return GivenTop
+	case up:
+		return GivenBottom
+	}
+	return GivenSub
+}
+
+// GivenAt returns the given that fully describes cell r,c of a field, where
+// ships don't touch each other.
+func GivenAt(field *Field, r, c int) Given {
+	if !field[r][c] {
+		return GivenWater
+	}
+	return cellGiven(r > 0 && field[r-1][c], r+1 < FieldHeight && field[r+1][c],
+		c > 0 && field[r][c-1], c+1 < FieldWidth && field[r][c+1])
+}
+
+// A Hint reveals the contents of a single cell.
+type Hint struct {
+	R, C  int
+	Given Given
+}
+
+// Matches returns whether the hint is consistent with the given field. If the
+// rules allow ships to touch, the shape of a segment can't be determined from
+// the field, and only its presence is checked.
+func (h Hint) Matches(field *Field) bool {
+	if h.Given == GivenShip || GameRules.Adjacency != NoTouch {
+		return field[h.R][h.C] == h.Given.Ship()
+	}
+	return GivenAt(field, h.R, h.C) == h.Given
+}
+
+// A Puzzle consists of row and column counts and hints, which together have
+// a unique solution.
+type Puzzle struct {
+	Rows     RowCounts
+	Cols     ColCounts
+	Hints    []Hint
+	Solution *Field
+}
+
+// filterHints returns the solutions that are consistent with the given hints.
+func filterHints(solutions []*Field, hints []Hint) []*Field {
+	count := 0
+	filtered := make([]*Field, len(solutions))
+loop:
+	for _, solution := range (solutions) {
+		for _, hint := range (hints) {
+			if !hint.Matches(solution) {
+				continue loop
+			}
+		}
+		filtered[count] = solution
+		count++
+	}
+	return filtered[0:count]
+}
+
+// containsField returns whether the given field is among the solutions.
+func containsField(solutions []*Field, field *Field) bool {
+loop:
+	for _, solution := range (solutions) {
+		for r := range (solution) {
+			for c := range (solution[r]) {
+				if solution[r][c] != field[r][c] {
+					continue loop
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GeneratePuzzle creates a puzzle with the given field as its solution. Hints
+// are added greedily, each time revealing the cell (and the shape of the ship
+// segment it contains, if any) that rules out the most remaining solutions,
+// until the solution is unique. Then hints are removed
+// again (in random order) as long as the solution remains unique, so that the
+// resulting set of hints is minimal: no hint can be left out. If the field
+// isn't a valid set-up, so that no puzzle has it as its solution, nil is
+// returned.
+func GeneratePuzzle(rng *rand.Rand, field *Field) *Puzzle {
+	rows, cols := CountShips(field)
+	solutions := ListSolutions(rows, cols)
+	if !containsField(solutions, field) {
+		return nil
+	}
+	var hinted Field
+	hints := make([]Hint, 0, FieldHeight*FieldWidth)
+	candidates := filterHints(solutions, hints)
+	for len(candidates) > 1 {
+		// Find the cell which leaves the fewest candidates when revealed:
+		var best Hint
+		bestCount, ties := len(candidates)+1, 0
+		for r := 0; r < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				if hinted[r][c] {
+					continue
+				}
+				hint, count := Hint{r, c, GivenAt(field, r, c)}, 0
+				if GameRules.Adjacency != NoTouch && hint.Given.Ship() {
+					hint.Given = GivenShip
+				}
+				for _, candidate := range (candidates) {
+					if hint.Matches(candidate) {
+						count++
+					}
+				}
+				if count < bestCount {
+					bestCount, ties = count, 0
+				}
+				if count == bestCount {
+					ties++
+					if rng.Intn(ties) == 0 {
+						best = hint
+					}
+				}
+			}
+		}
+		hinted[best.R][best.C] = true
+		hints = hints[0 : len(hints)+1]
+		hints[len(hints)-1] = best
+		candidates = filterHints(candidates, hints[len(hints)-1:])
+	}
+
+	// Remove redundant hints, in random order:
+	for i := len(hints) - 1; i > 0; i-- {
+		j := rng.Intn(i + 1)
+		hints[i], hints[j] = hints[j], hints[i]
+	}
+	for i := len(hints) - 1; i >= 0; i-- {
+		without := make([]Hint, len(hints)-1)
+		copy(without, hints[0:i])
+		copy(without[i:], hints[i+1:])
+		if len(filterHints(solutions, without)) == 1 {
+			hints = without
+		}
+	}
+	return &Puzzle{rows, cols, hints, field}
+}
+
+// Solutions returns all solutions of the puzzle.
+func (p *Puzzle) Solutions() []*Field { return ListSolutionsWithHints(p.Rows, p.Cols, p.Hints) }
+
+// String formats the puzzle for printing: a grid with the givens (see Given)
+// and '.' for unknown cells ('X' for land), with row counts to the right and column counts
+// below. The result can be parsed with ParsePuzzle.
+func (p *Puzzle) String() string {
+	var grid [FieldHeight][FieldWidth]byte
+	for r := range (grid) {
+		for c := range (grid[r]) {
+			if GameRules.Land[r][c] {
+				grid[r][c] = 'X'
+			} else {
+				grid[r][c] = '.'
+			}
+		}
+	}
+	for _, hint := range (p.Hints) {
+		grid[hint.R][hint.C] = byte(hint.Given)
+	}
+	result := "   "
+	for c := 0; c < FieldWidth; c++ {
+		result += " " + string('A'+c)
+	}
+	result += "\n"
+	for r := 0; r < FieldHeight; r++ {
+		result += fmt.Sprintf("%2d ", r+1)
+		for c := 0; c < FieldWidth; c++ {
+			result += " " + string(grid[r][c])
+		}
+		result += fmt.Sprintf("  %d\n", p.Rows[r])
+	}
+	result += "   "
+	for c := 0; c < FieldWidth; c++ {
+		result += fmt.Sprintf(" %d", p.Cols[c])
+	}
+	return result + "\n"
+}
+
+// parseCount parses a row or column count.
+func parseCount(desc string, max int) (int, bool) {
+	count, err := strconv.Atoi(desc)
+	return count, err == nil && count >= 0 && count <= max
+}
+
+// ParsePuzzle parses a puzzle in the format written by Puzzle.String. The
+// header line with column letters is optional. Land cells must match the land
+// of the current rules. Other text before and after the
+// puzzle, such as the title and solution printed by the solitaire tool, is
+// ignored. The solution is left nil.
+func ParsePuzzle(desc string) *Puzzle {
+	lines := make([][]string, 0, FieldHeight+1)
+	started := false
+	for _, line := range (strings.Split(desc, "\n", 0)) {
+		if len(lines) == cap(lines) {
+			break
+		}
+		fields := SplitFields(line)
+		if !started {
+			// The puzzle starts at the header line, or else the first row:
+			if len(fields) == FieldWidth && fields[0] == "A" {
+				started = true
+				continue
+			}
+			started = len(fields) == FieldWidth+2 && fields[0] == "1"
+		}
+		if started && len(fields) > 0 {
+			lines = lines[0 : len(lines)+1]
+			lines[len(lines)-1] = fields
+		}
+	}
+	if len(lines) != FieldHeight+1 {
+		return nil
+	}
+	p := &Puzzle{Hints: make([]Hint, 0, FieldHeight*FieldWidth)}
+	var ok bool
+	for r := 0; r < FieldHeight; r++ {
+		fields := lines[r]
+		if len(fields) != FieldWidth+2 || fields[0] != strconv.Itoa(r+1) {
+			return nil
+		}
+		for c, cell := range (fields[1 : FieldWidth+1]) {
+			if len(cell) != 1 || (cell == "X") != GameRules.Land[r][c] ||
+				cell != "." && cell != "X" && !validGiven(cell[0]) {
+				return nil
+			}
+			if cell != "." && cell != "X" {
+				p.Hints = p.Hints[0 : len(p.Hints)+1]
+				p.Hints[len(p.Hints)-1] = Hint{r, c, Given(cell[0])}
+			}
+		}
+		if p.Rows[r], ok = parseCount(fields[FieldWidth+1], FieldWidth); !ok {
+			return nil
+		}
+	}
+	if len(lines[FieldHeight]) != FieldWidth {
+		return nil
+	}
+	for c, field := range (lines[FieldHeight]) {
+		if p.Cols[c], ok = parseCount(field, FieldHeight); !ok {
+			return nil
+		}
+	}
+	return p
+}
@@ -0,0 +1,347 @@
+package records
+
+// Records describe generated set-up templates, as written by the generator and
+// kept in a corpus. A corpus is a JSON-lines file with one record per line,
+// indexed by the canonical signature of its counts (see game.CanonicalKey), and
+// sorted by that key.
+
+import (
+	"./game"
+	"fmt"
+	"io"
+	"os"
+	"rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Record describes a generated field.
+type Record struct {
+	Rows      game.RowCounts
+	Cols      game.ColCounts
+	Ships     *game.Field
+	Solutions int     // number of solutions for the field's counts
+	Expected  float64 // expected score of the greedy strategy
+	Worst     int     // worst-case score of the greedy strategy
+	Depth     int     // number of shots the greedy strategy needs for this field
+	Time      float64 // time spent generating the field (in seconds)
+}
+
+// Metrics lists the names of the metrics of a record.
+var Metrics = []string{"solutions", "expected", "worst", "depth", "time"}
+
+// Key returns the canonical signature of the record's counts.
+func (r *Record) Key() string { return game.CanonicalKey(r.Rows, r.Cols) }
+
+// Measured returns whether the strategy-based metrics have been computed.
+func (r *Record) Measured() bool { return r.Worst > 0 }
+
+// Measure computes the strategy-based difficulty metrics of the record, using
+// the given random number generator to break ties in the strategy.
+func (r *Record) Measure(rng *rand.Rand) {
+	strategy := game.CreateStrategy(rng, game.ListSolutions(r.Rows, r.Cols))
+	if strategy != nil {
+		r.Expected = float64(game.GetExpectedScore(strategy))
+		r.Worst = game.GetMaximumScore(strategy)
+		r.Depth = game.GetFieldScore(strategy, r.Ships)
+	}
+}
+
+// Canonicalize transforms the record to the canonical variant of its counts.
+func (r *Record) Canonicalize() {
+	var t game.Transform
+	r.Rows, r.Cols, t = game.Canonicalize(r.Rows, r.Cols)
+	r.Ships = t.Field(r.Ships)
+}
+
+// Metric returns the value of the named metric of the record.
+func (r *Record) Metric(name string) (float64, bool) {
+	switch name {
+	case "solutions":
+		return float64(r.Solutions), true
+	case "expected":
+		return r.Expected, true
+	case "worst":
+		return float64(r.Worst), true
+	case "depth":
+		return float64(r.Depth), true
+	case "time":
+		return r.Time, true
+	}
+	return 0, false
+}
+
+// Text formats a record as a line of space-separated values, starting with the
+// solution count, rows and columns (so the output can be loaded as templates).
+func (r *Record) Text() string {
+	return fmt.Sprintf("%d %s %s %s %.3f %d %d %.3f", r.Solutions,
+		game.FormatCounts(&r.Rows), game.FormatCounts(&r.Cols), game.FormatShips(r.Ships),
+		r.Expected, r.Worst, r.Depth, r.Time)
+}
+
+// JSON formats a record as a JSON object.
+func (r *Record) JSON() string {
+	return fmt.Sprintf("{\"key\":%q,\"rows\":%q,\"cols\":%q,\"ships\":%q,\"solutions\":%d,"+
+		"\"expected\":%.3f,\"worst\":%d,\"depth\":%d,\"time\":%.3f}",
+		r.Key(), game.FormatCounts(&r.Rows), game.FormatCounts(&r.Cols), game.FormatShips(r.Ships),
+		r.Solutions, r.Expected, r.Worst, r.Depth, r.Time)
+}
+
+// parseObject parses a flat JSON object with string and number values, as
+// written by Record.JSON, into a map of unquoted values.
+func parseObject(line string) map[string]string {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || line[0] != '{' || line[len(line)-1] != '}' {
+		return nil
+	}
+	values := make(map[string]string)
+	for _, member := range (strings.Split(line[1:len(line)-1], ",", 0)) {
+		parts := strings.Split(member, ":", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+		name, err := strconv.Unquote(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, "\"") {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil
+			}
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// Parse parses a record in either the text or the JSON format. Metrics that are
+// missing (as in the output of older versions of the generator, which wrote
+// only the solution count, or the solution count and the generation time) are
+// left zero.
+func Parse(line string) *Record {
+	var rows, cols, ships string
+	var numbers []string // solutions, expected, worst, depth, time
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		values := parseObject(line)
+		if values == nil {
+			return nil
+		}
+		rows, cols, ships = values["rows"], values["cols"], values["ships"]
+		numbers = make([]string, len(Metrics))
+		for i, name := range (Metrics) {
+			numbers[i] = values[name]
+		}
+	} else {
+		// The text format has been extended over time; the number of
+		// fields tells which metrics follow the ships:
+		parts := strings.Split(strings.TrimSpace(line), " ", 0)
+		numbers = make([]string, len(Metrics))
+		switch len(parts) {
+		case 4: // solutions rows cols ships
+		case 5: // ... time
+			numbers[4] = parts[4]
+		case 8: // ... expected worst depth time
+			copy(numbers[1:], parts[4:])
+		default:
+			return nil
+		}
+		rows, cols, ships = parts[1], parts[2], parts[3]
+		numbers[0] = parts[0]
+	}
+
+	r := &Record{}
+	if rowsPtr, colsPtr := game.ParseRows(rows), game.ParseCols(cols); rowsPtr == nil || colsPtr == nil {
+		return nil
+	} else {
+		r.Rows, r.Cols = *rowsPtr, *colsPtr
+	}
+	if r.Ships = game.ParseShips(ships); r.Ships == nil {
+		return nil
+	}
+	var err os.Error
+	if r.Solutions, err = strconv.Atoi(numbers[0]); err != nil {
+		return nil
+	}
+	for i, number := range (numbers[1:]) {
+		if number == "" {
+			continue
+		}
+		var value float64
+		if value, err = strconv.Atof64(number); err != nil {
+			return nil
+		}
+		switch Metrics[i+1] {
+		case "expected":
+			r.Expected = value
+		case "worst":
+			r.Worst = int(value)
+		case "depth":
+			r.Depth = int(value)
+		case "time":
+			r.Time = value
+		}
+	}
+	return r
+}
+
+// A Condition compares a metric against a fixed value.
+type Condition struct {
+	Metric, Op string
+	Value      float64
+}
+
+// ParseConditions parses a comma-separated list of conditions such as
+// "expected>=60,depth>70".
+func ParseConditions(desc string) ([]Condition, os.Error) {
+	if desc == "" {
+		return nil, nil
+	}
+	parts := strings.Split(desc, ",", 0)
+	conds := make([]Condition, len(parts))
+	for i, part := range (parts) {
+		pos := 0
+		for pos < len(part) && part[pos] != '<' && part[pos] != '>' && part[pos] != '=' {
+			pos++
+		}
+		if pos == 0 || pos == len(part) {
+			return nil, os.NewError("invalid condition: " + part)
+		}
+		op := part[pos : pos+1]
+		if pos+1 < len(part) && part[pos+1] == '=' {
+			op = part[pos : pos+2]
+		}
+		value, err := strconv.Atof64(part[pos+len(op):])
+		if err != nil {
+			return nil, os.NewError("invalid condition: " + part)
+		}
+		conds[i] = Condition{part[0:pos], op, value}
+		if _, ok := (&Record{}).Metric(conds[i].Metric); !ok {
+			return nil, os.NewError("unknown metric: " + conds[i].Metric)
+		}
+	}
+	return conds, nil
+}
+
+// Matches returns whether the record satisfies all conditions.
+func (r *Record) Matches(conds []Condition) bool {
+	for _, cond := range (conds) {
+		value, _ := r.Metric(cond.Metric)
+		var ok bool
+		switch cond.Op {
+		case "<":
+			ok = value < cond.Value
+		case "<=":
+			ok = value <= cond.Value
+		case ">":
+			ok = value > cond.Value
+		case ">=":
+			ok = value >= cond.Value
+		case "=", "==":
+			ok = value == cond.Value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedRecords sorts records by a metric, in decreasing order.
+type sortedRecords struct {
+	records []*Record
+	metric  string
+}
+
+func (sr *sortedRecords) Len() int { return len(sr.records) }
+func (sr *sortedRecords) Less(i, j int) bool {
+	vi, _ := sr.records[i].Metric(sr.metric)
+	vj, _ := sr.records[j].Metric(sr.metric)
+	return vi > vj
+}
+func (sr *sortedRecords) Swap(i, j int) {
+	sr.records[i], sr.records[j] = sr.records[j], sr.records[i]
+}
+
+// Sort sorts records by the named metric, in decreasing order.
+func Sort(records []*Record, metric string) { sort.Sort(&sortedRecords{records, metric}) }
+
+// A Corpus is a collection of records, with one record per canonical signature.
+type Corpus struct {
+	records map[string]*Record
+}
+
+// NewCorpus returns a new, empty corpus.
+func NewCorpus() *Corpus { return &Corpus{make(map[string]*Record)} }
+
+// Load reads a corpus from the given file. A missing file yields an empty corpus.
+func Load(filename string) (*Corpus, os.Error) {
+	corpus := NewCorpus()
+	data, err := io.ReadFile(filename)
+	if err != nil {
+		if _, statErr := os.Stat(filename); statErr != nil {
+			return corpus, nil
+		}
+		return nil, err
+	}
+	for i, line := range (strings.Split(string(data), "\n", 0)) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		r := Parse(line)
+		if r == nil {
+			return nil, os.NewError(filename + ":" + strconv.Itoa(i+1) + ": invalid record")
+		}
+		corpus.Add(r)
+	}
+	return corpus, nil
+}
+
+// Add adds a record to the corpus, in canonical form. If the corpus already
+// contains a record with the same signature, the new record only replaces it
+// if the existing record lacks the strategy-based metrics. Returns whether the
+// record was added.
+func (c *Corpus) Add(r *Record) bool {
+	r.Canonicalize()
+	key := r.Key()
+	if old, found := c.records[key]; found && (old.Measured() || !r.Measured()) {
+		return false
+	}
+	c.records[key] = r
+	return true
+}
+
+// Len returns the number of records in the corpus.
+func (c *Corpus) Len() int { return len(c.records) }
+
+// Records returns the records in the corpus, sorted by key.
+func (c *Corpus) Records() []*Record {
+	keys := make([]string, len(c.records))
+	i := 0
+	for key := range (c.records) {
+		keys[i] = key
+		i++
+	}
+	sort.SortStrings(keys)
+	result := make([]*Record, len(keys))
+	for i, key := range (keys) {
+		result[i] = c.records[key]
+	}
+	return result
+}
+
+// Save writes the corpus to the given file.
+func (c *Corpus) Save(filename string) os.Error {
+	file, err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, r := range (c.Records()) {
+		if _, err := fmt.Fprintln(file, r.JSON()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
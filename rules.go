@@ -0,0 +1,124 @@
+package game
+
+// Rule variants. The rules are global, like the field dimensions and the fleet,
+// and should be set before any fields are generated or solved.
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+// Adjacency rules, which determine whether ships may touch each other:
+const (
+	NoTouch       = iota // ships may not touch, not even diagonally
+	DiagonalTouch        // ships may touch diagonally, but not along a side
+	AnyTouch             // ships may touch in any way (but not overlap)
+	Adjacencies
+)
+
+var adjacencyNames = [Adjacencies]string{"none", "diagonal", "any"}
+
+// ParseAdjacency parses the name of an adjacency rule.
+func ParseAdjacency(name string) (int, bool) {
+	for adjacency, adjacencyName := range (adjacencyNames) {
+		if name == adjacencyName {
+			return adjacency, true
+		}
+	}
+	return 0, false
+}
+
+// AdjacencyName returns the name of an adjacency rule.
+func AdjacencyName(adjacency int) string { return adjacencyNames[adjacency] }
+
+// Rules describes the variant of the game being played.
+type Rules struct {
+	Adjacency int
+	Land      Field    // cells where no ship may be placed
+	Fleet     []*Shape // the ships to place, by decreasing size (see ParseFleet)
+}
+
+// GameRules are the rules currently in effect.
+var GameRules = Rules{Adjacency: NoTouch, Fleet: DefaultFleet()}
+
+// LoadBoard reads a board from the given file (see ParseBoard), and sets the
+// land cells of the rules accordingly.
+func LoadBoard(filename string) os.Error {
+	data, err := io.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	land := ParseBoard(string(data))
+	if land == nil {
+		return os.NewError(filename + ": invalid board")
+	}
+	GameRules.Land = *land
+	return nil
+}
+
+// RuleFlags are the command-line flags that select the rules, registered by
+// RegisterRuleFlags.
+type RuleFlags struct {
+	adjacency, board, fleet *string
+}
+
+// RegisterRuleFlags registers the flags "adjacency", "board" and "fleet", which
+// select the adjacency rule, a file with the board's land cells, and the
+// fleet. Tools whose flags are capitalized get "Adjacency", "Board" and "Fleet"
+// instead. The rules are set by RuleFlags.Apply, after parsing the flags.
+func RegisterRuleFlags(capitalize bool) *RuleFlags {
+	name := func(s string) string {
+		if capitalize {
+			return strings.ToUpper(s[0:1]) + s[1:]
+		}
+		return s
+	}
+	return &RuleFlags{
+		flag.String(name("adjacency"), adjacencyNames[NoTouch], name("adjacency rule: none, diagonal or any")),
+		flag.String(name("board"), "", name("file with the board's land cells")),
+		flag.String(name("fleet"), FormatFleet(DefaultFleet()), name("ships to place, e.g. \"5.4.L.T.3.3.2.2\"")),
+	}
+}
+
+// Apply sets the rules selected by the flags, and checks that the fleet can be
+// placed on the board.
+func (rf *RuleFlags) Apply() os.Error {
+	adjacency, ok := ParseAdjacency(*rf.adjacency)
+	if !ok {
+		return os.NewError("unknown adjacency rule: " + *rf.adjacency)
+	}
+	GameRules.Adjacency = adjacency
+	if err := SetFleet(*rf.fleet); err != nil {
+		return err
+	}
+	if *rf.board != "" {
+		if err := LoadBoard(*rf.board); err != nil {
+			return err
+		}
+	}
+	if !fleetFits() {
+		return os.NewError("could not place the fleet on the board")
+	}
+	return nil
+}
+
+// touchMargin returns the distance around a ship in which other ships are
+// (partially) blocked.
+func touchMargin() int {
+	if GameRules.Adjacency == AnyTouch {
+		return 0
+	}
+	return 1
+}
+
+// blockShip adds delta to the blocked count of each cell that is unavailable
+// to other ships, because of the given ship.
+func blockShip(blocked *[FieldHeight][FieldWidth]int, p Ship, delta int) {
+	for _, cell := range (p.Shape.Orientations[p.Orientation].Halo[GameRules.Adjacency]) {
+		if r, c := p.R+cell[0], p.C+cell[1]; inField(r, c) {
+			blocked[r][c] += delta
+		}
+	}
+}
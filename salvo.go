@@ -0,0 +1,203 @@
+package game
+
+// The salvo variant: each turn, a player fires a volley of several shots, and
+// only learns their results after the volley. Cells in a volley should be
+// chosen jointly: two cells that are hit by the same solutions tell us little
+// more than one of them, so the solver picks cells that together split the
+// remaining solutions into as many equally likely outcomes as possible.
+
+import (
+	"./util"
+	"math"
+	"rand"
+)
+
+// SalvoFunc returns the coordinates of n distinct cells to fire at in a single
+// volley, given the opponent's row and column counts and the shots fired so far.
+type SalvoFunc func(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int
+
+// FireSalvo returns a volley of n shots for the engine. Engines without a
+// salvo strategy of their own choose the shots one at a time (see
+// sequentialSalvo).
+func (e *Engine) FireSalvo(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int {
+	if e.Salvo != nil {
+		return e.Salvo(rng, rows, cols, shots, n)
+	}
+	return sequentialSalvo(e.Shoot, rng, rows, cols, shots, n)
+}
+
+// unfired returns the number of cells that haven't been fired at (or are land).
+func unfired(shots []Shot) (count int) {
+	fired := GameRules.Land
+	for _, s := range (shots) {
+		fired[s.R][s.C] = true
+	}
+	for r := range (fired) {
+		for c := range (fired[r]) {
+			if !fired[r][c] {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// sequentialSalvo builds a volley by calling a shooter n times, recording the
+// cells chosen so far as misses, which keeps the shooter from choosing them
+// again (but is otherwise only a rough approximation).
+func sequentialSalvo(shooter ShootFunc, rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int {
+	n = util.Min(n, unfired(shots))
+	pending := make([]Shot, len(shots), len(shots)+n)
+	copy(pending, shots)
+	volley := make([][2]int, n)
+	for i := range (volley) {
+		r, c := shooter(rng, rows, cols, pending)
+		volley[i] = [2]int{r, c}
+		pending = pending[0 : len(pending)+1]
+		pending[len(pending)-1] = Shot{r, c, false, 0}
+	}
+	return volley
+}
+
+// ShootSalvo returns the coordinates of n distinct unfired cells to fire at
+// in a single volley.
+func ShootSalvo(rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int {
+	return shootSalvo(newRand(), rows, cols, shots, n)
+}
+
+func shootSalvo(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int {
+	// Solve the canonical variant of the board, and map the result back:
+	rows, cols, t := Canonicalize(rows, cols)
+	volley := shootSalvoCanonical(rng, rows, cols, t.Shots(shots), n)
+	inverse := t.Inverse()
+	for i := range (volley) {
+		volley[i][0], volley[i][1] = inverse.Coords(volley[i][0], volley[i][1])
+	}
+	return volley
+}
+
+// entropy returns the contribution of an outcome with the given count, out of
+// a total number of solutions, to the entropy of the outcome distribution.
+func entropy(count, total int) float64 {
+	if count == 0 {
+		return 0
+	}
+	p := float64(count) / float64(total)
+	return -p * math.Log(p)
+}
+
+// shootSalvoCanonical selects a volley greedily: cells that are certain hits
+// come first, then each next cell is the one that maximizes the entropy of the
+// combined outcome of the volley over the remaining solutions (so correlated
+// cells are avoided), with ties broken by the number of solutions it hits.
+func shootSalvoCanonical(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot, n int) [][2]int {
+	solutions := findSolutions(rows, cols, shots, int64(TimeOut*1e9))
+	if solutions == nil {
+		// Solver timed out; use a less sophisticated algorithm:
+		return sequentialSalvo(simpleShoot, rng, rows, cols, shots, n)
+	}
+	if len(solutions) == 0 {
+		return sequentialSalvo(simpleShoot, rng, rows, cols, shots, n)
+	}
+
+	shot := GameRules.Land
+	for _, s := range (shots) {
+		shot[s.R][s.C] = true
+	}
+	n = util.Min(n, unfired(shots))
+
+	// The outcome class of each solution, i.e. the results of the shots
+	// selected so far if that solution were the opponent's field:
+	class := make([]int, len(solutions))
+	classes := 1
+
+	volley := make([][2]int, 0, n)
+	for len(volley) < n {
+		// Score each unfired cell, in parallel:
+		var scores [FieldHeight][FieldWidth]float64
+		var hits [FieldHeight][FieldWidth]int
+		{
+			var children int
+			notify := make(chan struct{}, FieldHeight*FieldWidth)
+			for r := 0; r < FieldHeight; r++ {
+				for c := 0; c < FieldWidth; c++ {
+					if !shot[r][c] {
+						children++
+						go func(r, c int) {
+							classHits := make([]int, classes)
+							classSizes := make([]int, classes)
+							for i, solution := range (solutions) {
+								classSizes[class[i]]++
+								if solution[r][c] {
+									classHits[class[i]]++
+									hits[r][c]++
+								}
+							}
+							for k := range (classHits) {
+								scores[r][c] += entropy(classHits[k], len(solutions)) +
+									entropy(classSizes[k]-classHits[k], len(solutions))
+							}
+							notify <- struct{}{}
+						}(r, c)
+					}
+				}
+			}
+			for children > 0 {
+				<-notify
+				children--
+			}
+		}
+
+		// Select the best cell, at random among ties:
+		bestR, bestC, ties := -1, -1, 0
+		for r := 0; r < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				if shot[r][c] {
+					continue
+				}
+				better, equal := bestR < 0, false
+				if !better {
+					certain, bestCertain := hits[r][c] == len(solutions), hits[bestR][bestC] == len(solutions)
+					switch {
+					case certain != bestCertain:
+						better = certain
+					case math.Fabs(scores[r][c]-scores[bestR][bestC]) > 1e-9:
+						better = scores[r][c] > scores[bestR][bestC]
+					default:
+						better, equal = hits[r][c] > hits[bestR][bestC], hits[r][c] == hits[bestR][bestC]
+					}
+				}
+				if better {
+					bestR, bestC, ties = r, c, 1
+				} else if equal {
+					if ties++; rng.Intn(ties) == 0 {
+						bestR, bestC = r, c
+					}
+				}
+			}
+		}
+		shot[bestR][bestC] = true
+		volley = volley[0 : len(volley)+1]
+		volley[len(volley)-1] = [2]int{bestR, bestC}
+
+		// Split the outcome classes by the result of the new shot, and
+		// renumber them densely:
+		index := make([]int, 2*classes)
+		for k := range (index) {
+			index[k] = -1
+		}
+		classes = 0
+		for i, solution := range (solutions) {
+			k := 2 * class[i]
+			if solution[bestR][bestC] {
+				k++
+			}
+			if index[k] < 0 {
+				index[k] = classes
+				classes++
+			}
+			class[i] = index[k]
+		}
+	}
+	return volley
+}
@@ -8,11 +8,19 @@ import (
 	"log"
 	"malloc"
 	"io"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 	"rand"
 )
 
+// engine is the engine used to set up fields and fire shots
+var engine = game.Engines["default"]
+
+// newRand returns a new random number generator for handling a single request
+func newRand() *rand.Rand { return rand.New(rand.NewSource(rand.Int63())) }
+
 func PlayerServer(conn *http.Conn, request *http.Request) {
 	if request.ParseForm() != nil {
 		conn.WriteHeader(http.StatusInternalServerError)
@@ -26,9 +34,17 @@ func PlayerServer(conn *http.Conn, request *http.Request) {
 	} else {
 		switch action[0] {
 		case "Ships":
-			field := game.Setup()
-			response = game.FormatShips(field)
-			succeeded = true
+			if difficulty, ok := request.Form["Difficulty"]; !ok {
+				field := engine.Setup(newRand())
+				response = game.FormatShips(field)
+				succeeded = true
+			} else if level, ok := game.ParseDifficulty(difficulty[0]); !ok {
+				response = "invalid Difficulty value"
+			} else {
+				field := game.SetupWithDifficulty(newRand(), level)
+				response = game.FormatShips(field)
+				succeeded = true
+			}
 		case "Fire":
 			if rows, ok := request.Form["Rows"]; !ok {
 				response = "no Rows parameter supplied"
@@ -42,10 +58,16 @@ func PlayerServer(conn *http.Conn, request *http.Request) {
 				response = "no Shots parameter supplied"
 			} else if shots := game.ParseShots(shots[0]); shots == nil {
 				response = "invalid shot data"
-			} else {
-				r, c := game.Shoot(*rows, *cols, shots)
+			} else if salvo, ok := request.Form["Salvo"]; !ok {
+				r, c := engine.Shoot(newRand(), *rows, *cols, shots)
 				response = game.FormatCoords(r, c)
 				succeeded = true
+			} else if n, err := strconv.Atoi(salvo[0]); err != nil || n < 0 {
+				response = "invalid Salvo value"
+			} else {
+				volley := engine.FireSalvo(newRand(), *rows, *cols, shots, n)
+				response = game.FormatVolley(volley)
+				succeeded = true
 			}
 		case "Finished":
 			if ships, ok := request.Form["Ships"]; !ok {
@@ -53,8 +75,14 @@ func PlayerServer(conn *http.Conn, request *http.Request) {
 			} else if ships := game.ParseShips(ships[0]); ships == nil {
 				response = "invalid ship data"
 			} else {
+				// Purge the solutions even if the fleet is invalid, so
+				// they don't stay cached:
 				game.PurgeCache(game.CountShips(ships))
-				succeeded = true
+				if _, violations := game.ValidateFleet(ships); violations != nil {
+					response = "invalid fleet: " + strings.Join(violations, "; ")
+				} else {
+					succeeded = true
+				}
 			}
 			// Run GC here, to ensure we have free memory for the next game:
 			malloc.GC()
@@ -102,7 +130,42 @@ func main() {
 	port := flag.Int("p", 14000, "port to bind")
 	path := flag.String("r", "/player", "root path for player")
 	flag.FloatVar(&game.TimeOut, "t", 4.8, "move timeout")
+	engineName := flag.String("e", "default", "engine to play with")
+	templates := flag.String("T", "", "file with set-up templates")
+	mixture := flag.String("M", "", "file with a mixed set-up strategy")
+	grade := flag.Bool("D", false, "grade templates by difficulty at startup, instead of on the first Difficulty request")
+	rules := game.RegisterRuleFlags(false)
 	flag.Parse()
+	if err := rules.Apply(); err != nil {
+		log.Stderr(err.String())
+		os.Exit(1)
+	}
+	if *templates != "" {
+		if err := game.LoadTemplates(*templates); err != nil {
+			log.Stderr("Could not load templates: " + err.String())
+			os.Exit(1)
+		}
+	}
+	if *mixture != "" {
+		if err := game.LoadMixture(*mixture); err != nil {
+			log.Stderr("Could not load mixture: " + err.String())
+			os.Exit(1)
+		}
+	}
+	if engine = game.LookupEngine(*engineName); engine == nil {
+		log.Stderr("Unknown engine: " + *engineName)
+		os.Exit(1)
+	}
+
+	if *grade {
+		pools := game.GradeTemplatePools()
+		for level := range (pools) {
+			if len(pools[level]) == 0 {
+				log.Stderr("No " + game.DifficultyName(level) + " templates; " +
+					"using easier set-ups or randomly placed ships instead")
+			}
+		}
+	}
 	addr := *host + ":" + strconv.Itoa(*port)
 
 	// Start an HTTP server with a player handler:
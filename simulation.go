@@ -0,0 +1,206 @@
+package game
+
+// Self-play simulation: two engines play a series of games against each other
+// in-process, without going through the HTTP server, so that changes to the
+// engine can be evaluated on thousands of games at once.
+
+import (
+	"fmt"
+	"math"
+	"rand"
+	"sync"
+)
+
+// SetupFunc returns a new field set-up, drawing random numbers from rng.
+type SetupFunc func(rng *rand.Rand) *Field
+
+// ShootFunc returns the coordinates of the next cell to fire at, given the
+// opponent's row and column counts and the shots fired so far.
+type ShootFunc func(rng *rand.Rand, rows RowCounts, cols ColCounts, shots []Shot) (r, c int)
+
+// An Engine combines a setup policy with a shooting strategy, and optionally a
+// strategy for firing volleys in the salvo variant (see FireSalvo).
+type Engine struct {
+	Name  string
+	Setup SetupFunc
+	Shoot ShootFunc
+	Salvo SalvoFunc
+}
+
+// MaxShots is the maximum number of shots that can be fired in a single game.
+const MaxShots = FieldHeight * FieldWidth
+
+// PlayOut lets the shooter fire at the given field until all of its ships are
+// sunk, and returns the number of shots that took. Shooters that keep firing at
+// cells that were already fired at are cut off after MaxShots shots.
+func PlayOut(shooter ShootFunc, rng *rand.Rand, field *Field) int {
+	rows, cols := CountShips(field)
+	var remaining int
+	for _, count := range (rows) {
+		remaining += count
+	}
+	var fired Field
+	shots := make([]Shot, 0, MaxShots)
+	for remaining > 0 && len(shots) < MaxShots {
+		r, c := shooter(rng, rows, cols, shots)
+		if field[r][c] && !fired[r][c] {
+			remaining--
+		}
+		fired[r][c] = true
+		shots = shots[0 : len(shots)+1]
+		shots[len(shots)-1] = Shot{r, c, field[r][c], 0}
+	}
+	return len(shots)
+}
+
+// SimulationResult summarizes a series of games between two engines. Wins,
+// losses and draws are counted from the perspective of the first engine.
+type SimulationResult struct {
+	Engines             [2]*Engine
+	Games               int
+	Wins, Losses, Draws int
+	Shots               [2][MaxShots + 1]int // histograms of shots needed
+}
+
+// gameResult records the number of shots each engine needed in a single game.
+type gameResult struct {
+	shots [2]int
+}
+
+// cacheUsers counts the games in progress that use the cached solutions for
+// each pair of counts. Games played concurrently often share counts (when their
+// fields come from the same template), so solutions are only purged from the
+// cache once no game in progress needs them anymore.
+type cacheUsers struct {
+	mutex sync.Mutex
+	count map[string]int
+}
+
+// acquire registers a game in progress against the given field.
+func (cu *cacheUsers) acquire(field *Field) {
+	key := CanonicalKey(CountShips(field))
+	cu.mutex.Lock()
+	cu.count[key]++
+	cu.mutex.Unlock()
+}
+
+// release unregisters a game against the given field, and purges the cached
+// solutions for its counts if no other game uses them.
+func (cu *cacheUsers) release(field *Field) {
+	key := CanonicalKey(CountShips(field))
+	cu.mutex.Lock()
+	defer cu.mutex.Unlock()
+	if cu.count[key]--; cu.count[key] == 0 {
+		cu.count[key] = 0, false
+		PurgeCache(CountShips(field))
+	}
+}
+
+// playGame plays a single game between two engines. Both engines fire at each
+// other's fleet until it is sunk; the engine that needed fewer shots wins. This
+// is equivalent to alternating turns where the first move alternates too.
+func playGame(a, b *Engine, rng *rand.Rand, users *cacheUsers) (res gameResult) {
+	fieldA := a.Setup(rng)
+	fieldB := b.Setup(rng)
+	users.acquire(fieldA)
+	users.acquire(fieldB)
+	res.shots[0] = PlayOut(a.Shoot, rng, fieldB)
+	res.shots[1] = PlayOut(b.Shoot, rng, fieldA)
+	users.release(fieldA)
+	users.release(fieldB)
+	return
+}
+
+// Simulate plays the given number of games between engines a and b, using the
+// given number of concurrent workers. Game i is played with a random number
+// generator seeded with seed+i, so the results are reproducible regardless of
+// the number of workers, unless an engine's solver runs into its TimeOut, or
+// the adversarial engine is used with more than one worker: the hardest fields
+// of each template are computed with the generator of the first game that
+// needs them, which depends on the order in which the workers get to them.
+func Simulate(a, b *Engine, games int, seed int64, workers int) *SimulationResult {
+	jobs := make(chan int, games)
+	for i := 0; i < games; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	results := make(chan gameResult, games)
+	users := &cacheUsers{count: make(map[string]int)}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := <-jobs; !closed(jobs); i = <-jobs {
+				results <- playGame(a, b, rand.New(rand.NewSource(seed+int64(i))), users)
+			}
+		}()
+	}
+	res := &SimulationResult{Engines: [2]*Engine{a, b}, Games: games}
+	for i := 0; i < games; i++ {
+		gr := <-results
+		switch {
+		case gr.shots[0] < gr.shots[1]:
+			res.Wins++
+		case gr.shots[0] > gr.shots[1]:
+			res.Losses++
+		default:
+			res.Draws++
+		}
+		res.Shots[0][gr.shots[0]]++
+		res.Shots[1][gr.shots[1]]++
+	}
+	return res
+}
+
+// Score returns the first engine's score (wins plus half of the draws) as a
+// fraction of the games played, together with the bounds of its 95% Wilson
+// score confidence interval.
+func (res *SimulationResult) Score() (score, low, high float64) {
+	if res.Games == 0 {
+		return 0, 0, 1
+	}
+	const z = 1.96
+	n := float64(res.Games)
+	score = (float64(res.Wins) + float64(res.Draws)/2) / n
+	center := score + z*z/(2*n)
+	spread := z * math.Sqrt(score*(1-score)/n+z*z/(4*n*n))
+	low = (center - spread) / (1 + z*z/n)
+	high = (center + spread) / (1 + z*z/n)
+	return
+}
+
+// ShotStats returns the mean and standard deviation of the number of shots
+// engine i (0 or 1) needed to sink its opponent's fleet.
+func (res *SimulationResult) ShotStats(i int) (mean, stddev float64) {
+	var n, sum, sumSq float64
+	for shots, count := range (res.Shots[i]) {
+		n += float64(count)
+		sum += float64(count * shots)
+		sumSq += float64(count * shots * shots)
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	mean = sum / n
+	if variance := sumSq/n - mean*mean; variance > 0 {
+		stddev = math.Sqrt(variance)
+	}
+	return
+}
+
+// String formats the simulation results as a human-readable report.
+func (res *SimulationResult) String() string {
+	score, low, high := res.Score()
+	result := fmt.Sprintf("%s vs %s: %d games, %d wins, %d losses, %d draws\n",
+		res.Engines[0].Name, res.Engines[1].Name, res.Games, res.Wins, res.Losses, res.Draws)
+	result += fmt.Sprintf("score: %.3f (95%% CI %.3f-%.3f)\n", score, low, high)
+	for i := 0; i < 2; i++ {
+		mean, stddev := res.ShotStats(i)
+		result += fmt.Sprintf("%s shots: mean %.2f, stddev %.2f\n", res.Engines[i].Name, mean, stddev)
+	}
+	result += "shots\t" + res.Engines[0].Name + "\t" + res.Engines[1].Name + "\n"
+	for shots := 0; shots <= MaxShots; shots++ {
+		if res.Shots[0][shots] > 0 || res.Shots[1][shots] > 0 {
+			result += fmt.Sprintf("%d\t%d\t%d\n", shots, res.Shots[0][shots], res.Shots[1][shots])
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package main
+
+// A tool to generate solitaire puzzles with a unique solution, or to solve
+// puzzles read from a file (in the format written by this tool).
+
+import (
+	"./game"
+	"flag"
+	"fmt"
+	"io"
+	"rand"
+	"time"
+)
+
+// solve prints the steps taken by the deduction solver for the given puzzle.
+func solve(puzzle *game.Puzzle) {
+	d := game.Deduce(puzzle.Rows, puzzle.Cols, puzzle.Hints)
+	for _, step := range (d.Steps) {
+		if step.Rule != "hint" && step.Rule != "land" {
+			fmt.Println(step)
+		}
+	}
+	if !d.Solved() {
+		fmt.Println("Stuck after", len(d.Steps), "steps; ship segments found so far:")
+		fmt.Print(d.Field())
+	}
+	fmt.Println("Grade:", d.GradeName())
+}
+
+func main() {
+	// Parse command line arguments:
+	count := flag.Int("n", 1, "number of puzzles to generate")
+	seed := flag.Int64("s", 0, "random seed (0 to pick at random)")
+	ships := flag.String("ships", "", "solution to generate a puzzle for (default: random)")
+	solution := flag.Bool("solution", false, "print the solution after each puzzle")
+	deduce := flag.Bool("solve", false, "solve each puzzle by deduction, printing each step")
+	input := flag.String("puzzle", "", "file containing a puzzle to solve, instead of generating puzzles")
+	rules := game.RegisterRuleFlags(false)
+	flag.Parse()
+
+	if err := rules.Apply(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *input != "" {
+		data, err := io.ReadFile(*input)
+		if err != nil {
+			fmt.Println("Couldn't read puzzle:", err)
+			return
+		}
+		puzzle := game.ParsePuzzle(string(data))
+		if puzzle == nil {
+			fmt.Println("Couldn't parse puzzle in", *input)
+			return
+		}
+		fmt.Print(puzzle)
+		if *deduce {
+			solve(puzzle)
+		}
+		solutions := puzzle.Solutions()
+		fmt.Println("Solutions:", len(solutions))
+		if *solution {
+			for _, field := range (solutions) {
+				fmt.Println(game.FormatShips(field))
+			}
+		}
+		return
+	}
+
+	if *seed == 0 {
+		*seed = time.Nanoseconds()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	var field *game.Field
+	if *ships != "" {
+		if field = game.ParseShips(*ships); field == nil {
+			fmt.Println("Couldn't parse field description:", *ships)
+			return
+		}
+		if _, violations := game.ValidateFleet(field); violations != nil {
+			fmt.Println("Invalid fleet:", *ships)
+			for _, violation := range (violations) {
+				fmt.Println("\t" + violation)
+			}
+			return
+		}
+	}
+	for i := 0; i < *count; i++ {
+		f := field
+		if f == nil {
+			f = game.RandomField(rng)
+		}
+		puzzle := game.GeneratePuzzle(rng, f)
+		if puzzle == nil {
+			fmt.Println("Couldn't generate a puzzle for:", game.FormatShips(f))
+			return
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Puzzle %d (%d hints):\n", i+1, len(puzzle.Hints))
+		fmt.Print(puzzle)
+		if *deduce {
+			solve(puzzle)
+		}
+		if *solution {
+			fmt.Println("Solution:", game.FormatShips(puzzle.Solution))
+			fmt.Print(puzzle.Solution)
+		}
+	}
+}
@@ -10,19 +10,29 @@ import "runtime"
 
 // solverstate describes a partial solution, used by placeShips:
 type solverState struct {
-	rows    RowCounts
-	cols    ColCounts
-	ships   Field
-	blocked [FieldHeight][FieldWidth]int
-	results chan *Field
+	rows        RowCounts
+	cols        ColCounts
+	ships       Field
+	blocked     [FieldHeight][FieldWidth]int
+	givens      *[FieldHeight][FieldWidth]Given // shapes of revealed ship segments, or nil
+	sunk        *[FieldHeight][FieldWidth]int   // sizes of ships sunk by shots, or nil
+	fleet       []*Shape                        // the ships to place (see Rules)
+	even        []bool                          // whether the ships from each index on have even sizes
+	placed      []Ship                          // the ship placed for each index of the fleet, or nil
+	results     chan *Field
+	shipResults chan []Ship // receives the ships placed instead of fields, if not nil
 }
 
 // Returns a new copy of a partial solution. The only reason that this is in a
 // separate function, is that inlining it into placeShips significantly reduces
 // performance. (Probably a compiler bug.)
 func copyState(ss *solverState) *solverState {
-	copy := *ss
-	return &copy
+	state := *ss
+	if ss.placed != nil {
+		state.placed = make([]Ship, len(ss.placed))
+		copy(state.placed, ss.placed)
+	}
+	return &state
 }
 
 // TODO: document this
@@ -58,8 +68,8 @@ func checkCounts2(counts []int) bool {
 }
 
 // placeShips is the solver's workhorse. It takes a partially solved field with
-// ships, a field of blocked cells, row and column counts, the next ship to
-// place, and where the last ship was placed (start_r, start_c), and then
+// ships, a field of blocked cells, row and column counts, the next ship of the
+// fleet to place, and where to start placing it (start_r, start_c), and then
 // computes all remaining solutions to the grid, which are sent to the results
 // channel.
 //
@@ -67,9 +77,10 @@ func checkCounts2(counts []int) bool {
 // have been sent to the results channel. Specifically, if the routine spawns
 // new goroutines, it should wait for them to finish before returning!
 func placeShips(ss *solverState, ship, start_r, start_c int, notify chan<- struct{}) {
+	shape := ss.fleet[ship]
 
 	// Check if we need to restart placing ship at the top left corner:
-	if ship > 0 && ShipLengths[ship] != ShipLengths[ship-1] {
+	if ship > 0 && shape != ss.fleet[ship-1] {
 		start_r = 0
 		start_c = 0
 		runtime.Gosched()
@@ -83,110 +94,107 @@ func placeShips(ss *solverState, ship, start_r, start_c int, notify chan<- struc
 	}
 
 	// Search over all remaining positions for this type of ship:
-	for dir := 0; dir < 2; dir++ {
-		h := dir*(ShipLengths[ship]-1) + 1
-		w := (1-dir)*(ShipLengths[ship]-1) + 1
+	for orientation, o := range (shape.Orientations) {
+		h, w := len(o.Rows), len(o.Cols)
 
+	rows:
 		for r1 := start_r; r1 <= FieldHeight-h; r1++ {
-			if ss.rows[r1] < w {
-				continue
+			for i, n := range (o.Rows) {
+				if ss.rows[r1+i] < n {
+					continue rows
+				}
 			}
 		loop:
-			for c1 := util.Ifc(r1 == start_r, start_c, 0); c1 <= FieldWidth-w; c1++ {
+			for c1 := util.Max(util.Ifc(r1 == start_r, start_c, 0), -o.Left); c1+o.Left <= FieldWidth-w; c1++ {
 
-				if ss.cols[c1] < h || ss.blocked[r1][c1] > 0 {
+				if ss.blocked[r1][c1] > 0 {
 					continue
 				}
 
 				// Check if space is available here:
-				r2, c2 := r1+h, c1+w
-				if c2 > FieldWidth || r2 > FieldHeight {
-					continue
-				}
-				for r := r1; r < r2; r++ {
-					if ss.rows[r] < w {
+				for j, n := range (o.Cols) {
+					if ss.cols[c1+o.Left+j] < n {
 						continue loop
 					}
 				}
-				for c := c1; c < c2; c++ {
-					if ss.cols[c] < h {
+				for _, cell := range (o.Cells) {
+					if ss.blocked[r1+cell[0]][c1+cell[1]] > 0 {
 						continue loop
 					}
 				}
-				for r := r1; r < r2; r++ {
-					for c := c1; c < c2; c++ {
-						if ss.blocked[r][c] > 0 {
+				if ss.givens != nil {
+					for k, cell := range (o.Cells) {
+						given := ss.givens[r1+cell[0]][c1+cell[1]]
+						if given != 0 && given != GivenShip && given != o.Givens[k] {
 							continue loop
 						}
 					}
 				}
-
-				// Calculate area blocked by the ship:
-				br1 := util.Max(0, r1-1)
-				bc1 := util.Max(0, c1-1)
-				br2 := util.Min(FieldHeight, r2+1)
-				bc2 := util.Min(FieldWidth, c2+1)
+				if ss.sunk != nil && !sunkFits(ss, o, r1, c1) {
+					continue
+				}
 
 				// Claim space
-				for r := r1; r < r2; r++ {
-					ss.rows[r] -= w
+				for i, n := range (o.Rows) {
+					ss.rows[r1+i] -= n
 				}
-				for c := c1; c < c2; c++ {
-					ss.cols[c] -= h
+				for j, n := range (o.Cols) {
+					ss.cols[c1+o.Left+j] -= n
 				}
-				for r := r1; r < r2; r++ {
-					for c := c1; c < c2; c++ {
-						ss.ships[r][c] = true
-					}
+				for _, cell := range (o.Cells) {
+					ss.ships[r1+cell[0]][c1+cell[1]] = true
 				}
-				for r := br1; r < br2; r++ {
-					for c := bc1; c < bc2; c++ {
-						ss.blocked[r][c]++
-					}
+				blockShip(&ss.blocked, Ship{shape, orientation, r1, c1}, 1)
+				if ss.placed != nil {
+					ss.placed[ship] = Ship{shape, orientation, r1, c1}
 				}
 
-				if ship+1 == len(ShipLengths) {
-					result := ss.ships // make a copy
-					ss.results <- &result
+				if ship+1 == len(ss.fleet) {
+					if ss.givens == nil || coversGivens(&ss.ships, ss.givens) {
+						if ss.shipResults != nil {
+							result := make([]Ship, len(ss.placed))
+							copy(result, ss.placed)
+							ss.shipResults <- result
+						} else {
+							result := ss.ships // make a copy
+							ss.results <- &result
+						}
+					}
 				} else {
 					// Quick check to see if field is still solvable:
-					if ShipLengths[ship+1] > 2 {
-						if !checkCounts(&ss.rows) || !checkCounts(&ss.cols) {
+					if ss.even[ship+1] {
+						if !checkCounts2(&ss.rows) || !checkCounts2(&ss.cols) {
 							goto unsolvable
 						}
-					} else {
-						if !checkCounts2(&ss.rows) || !checkCounts2(&ss.cols) {
+					} else if ss.fleet[len(ss.fleet)-1].Size() > 1 {
+						if !checkCounts(&ss.rows) || !checkCounts(&ss.cols) {
 							goto unsolvable
 						}
 					}
 
-					// Solve recursively
+					// Solve recursively; the next ship of the same shape
+					// can't be anchored next to this one:
+					next := c1 + o.Run + touchMargin()
 					if childNotify == nil {
-						placeShips(ss, ship+1, r1, bc2, nil)
+						placeShips(ss, ship+1, r1, next, nil)
 					} else {
-						go placeShips(copyState(ss), ship+1, r1, bc2, childNotify)
+						go placeShips(copyState(ss), ship+1, r1, next, childNotify)
 						children++
 					}
 				}
 
 				// Return claimed space
 			unsolvable:
-				for r := r1; r < r2; r++ {
-					ss.rows[r] += w
+				for i, n := range (o.Rows) {
+					ss.rows[r1+i] += n
 				}
-				for c := c1; c < c2; c++ {
-					ss.cols[c] += h
+				for j, n := range (o.Cols) {
+					ss.cols[c1+o.Left+j] += n
 				}
-				for r := r1; r < r2; r++ {
-					for c := c1; c < c2; c++ {
-						ss.ships[r][c] = false
-					}
-				}
-				for r := br1; r < br2; r++ {
-					for c := bc1; c < bc2; c++ {
-						ss.blocked[r][c]--
-					}
+				for _, cell := range (o.Cells) {
+					ss.ships[r1+cell[0]][c1+cell[1]] = false
 				}
+				blockShip(&ss.blocked, Ship{shape, orientation, r1, c1}, -1)
 			}
 		}
 	}
@@ -199,23 +207,144 @@ func placeShips(ss *solverState, ship, start_r, start_c int, notify chan<- struc
 	}
 }
 
+// sunkFits returns whether a ship in the given orientation at r1,c1 is
+// consistent with the ships sunk: if it covers the cell where a ship was sunk,
+// it must have the size of that ship, and all of its cells must have been hit.
+func sunkFits(ss *solverState, o *Orientation, r1, c1 int) bool {
+	sunk := false
+	for _, cell := range (o.Cells) {
+		if n := ss.sunk[r1+cell[0]][c1+cell[1]]; n > 0 {
+			if n != len(o.Cells) {
+				return false
+			}
+			sunk = true
+		}
+	}
+	if sunk {
+		for _, cell := range (o.Cells) {
+			if given := ss.givens[r1+cell[0]][c1+cell[1]]; given == 0 || !given.Ship() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// coversGivens returns whether all revealed ship segments are part of a ship.
+func coversGivens(ships *Field, givens *[FieldHeight][FieldWidth]Given) bool {
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if givens[r][c] != 0 && givens[r][c].Ship() && !ships[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // GenerateSolutions writes all solution fields for the given row and column
 // counts to a channel (and then a nil value to terminate the list).
 func GenerateSolutions(rows RowCounts, cols ColCounts) <-chan *Field {
+	return GenerateSolutionsWithHints(rows, cols, nil)
+}
+
+// GenerateSolutionsWithHints is like GenerateSolutions, but only generates
+// solutions that match the given hints. Water hints are never covered by a
+// ship, and ship segments are only placed where they match the revealed shape.
+func GenerateSolutionsWithHints(rows RowCounts, cols ColCounts, hints []Hint) <-chan *Field {
+	return generateSolutions(rows, cols, hints, nil)
+}
+
+// GenerateSolutionsWithShots is like GenerateSolutions, but only generates
+// solutions that are consistent with the shots fired: hits are covered by a
+// ship, misses aren't, and each sunk ship is a ship of the announced size that
+// is covered by hits only.
+func GenerateSolutionsWithShots(rows RowCounts, cols ColCounts, shots []Shot) <-chan *Field {
+	hints := make([]Hint, len(shots))
+	var sunk *[FieldHeight][FieldWidth]int
+	for i, shot := range (shots) {
+		hints[i] = Hint{shot.R, shot.C, GivenWater}
+		if shot.Hit {
+			hints[i].Given = GivenShip
+		}
+		if shot.Sunk > 0 {
+			if sunk == nil {
+				sunk = new([FieldHeight][FieldWidth]int)
+			}
+			sunk[shot.R][shot.C] = shot.Sunk
+		}
+	}
+	return generateSolutions(rows, cols, hints, sunk)
+}
+
+// newSolverState returns the initial state for solving a field with the given
+// counts, hints and sunk ships (which may be nil).
+func newSolverState(rows RowCounts, cols ColCounts, hints []Hint, sunk *[FieldHeight][FieldWidth]int) *solverState {
+	state := &solverState{rows: rows, cols: cols, sunk: sunk, fleet: GameRules.Fleet}
+	state.even = make([]bool, len(state.fleet)+1)
+	state.even[len(state.fleet)] = true
+	for i := len(state.fleet) - 1; i >= 0; i-- {
+		state.even[i] = state.even[i+1] && state.fleet[i].Size()%2 == 0
+	}
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if GameRules.Land[r][c] {
+				state.blocked[r][c]++
+			}
+		}
+	}
+	if len(hints) > 0 {
+		state.givens = new([FieldHeight][FieldWidth]Given)
+		for _, hint := range (hints) {
+			state.givens[hint.R][hint.C] = hint.Given
+			if !hint.Given.Ship() {
+				state.blocked[hint.R][hint.C]++
+			}
+		}
+	}
+	return state
+}
+
+func generateSolutions(rows RowCounts, cols ColCounts, hints []Hint, sunk *[FieldHeight][FieldWidth]int) <-chan *Field {
 	results := make(chan *Field, 1000000) // expect lots of solutions
 	go func() {
-		state := solverState{rows: rows, cols: cols, results: results}
-		placeShips(&state, 0, 0, 0, nil)
+		state := newSolverState(rows, cols, hints, sunk)
+		state.results = results
+		placeShips(state, 0, 0, 0, nil)
 		results <- nil
 	}()
 	return results
 }
 
-// ListSolutions returns a slice with all solutions for the given field counts
-func ListSolutions(rows RowCounts, cols ColCounts) (solutions []*Field) {
-	ch := GenerateSolutions(rows, cols)
+// GenerateShipSolutions is like GenerateSolutions, but writes each solution as
+// the list of ships placed by the solver, in the order of the fleet, for
+// analysis at the level of individual ships. After the last solution, nil is
+// written.
+func GenerateShipSolutions(rows RowCounts, cols ColCounts) <-chan []Ship {
+	results := make(chan []Ship, 1000000) // expect lots of solutions
+	go func() {
+		state := newSolverState(rows, cols, nil, nil)
+		state.placed = make([]Ship, len(state.fleet))
+		state.shipResults = results
+		placeShips(state, 0, 0, 0, nil)
+		results <- nil
+	}()
+	return results
+}
+
+// progressInterval is the number of solutions between progress reports (see
+// collectSolutions).
+const progressInterval = 1000
+
+// collectSolutions returns a slice with all solutions written to a channel. If
+// progress isn't nil, it is called with the number of solutions collected so
+// far, after every progressInterval solutions.
+func collectSolutions(ch <-chan *Field, progress func(int)) (solutions []*Field) {
 	for sol := <-ch; sol != nil; sol = <-ch {
 		i := len(solutions)
+		if progress != nil && i%progressInterval == 0 {
+			progress(i)
+		}
 		if i == cap(solutions) {
 			tmp := make([]*Field, i, util.Max(2*i, 16))
 			copy(tmp, solutions)
@@ -227,6 +356,40 @@ func ListSolutions(rows RowCounts, cols ColCounts) (solutions []*Field) {
 	return
 }
 
+// ListSolutions returns a slice with all solutions for the given field counts
+func ListSolutions(rows RowCounts, cols ColCounts) []*Field {
+	return collectSolutions(GenerateSolutions(rows, cols), nil)
+}
+
+// ListSolutionsWithHints returns a slice with all solutions for the given
+// field counts that match the given hints.
+func ListSolutionsWithHints(rows RowCounts, cols ColCounts, hints []Hint) []*Field {
+	return collectSolutions(GenerateSolutionsWithHints(rows, cols, hints), nil)
+}
+
+// ListSolutionsWithShots returns a slice with all solutions for the given
+// field counts that are consistent with the shots fired.
+func ListSolutionsWithShots(rows RowCounts, cols ColCounts, shots []Shot) []*Field {
+	return collectSolutions(GenerateSolutionsWithShots(rows, cols, shots), nil)
+}
+
+// ListShipSolutions returns a slice with all solutions for the given field
+// counts, as lists of ships (see GenerateShipSolutions).
+func ListShipSolutions(rows RowCounts, cols ColCounts) (solutions [][]Ship) {
+	ch := GenerateShipSolutions(rows, cols)
+	for sol := <-ch; sol != nil; sol = <-ch {
+		i := len(solutions)
+		if i == cap(solutions) {
+			tmp := make([][]Ship, i, util.Max(2*i, 16))
+			copy(tmp, solutions)
+			solutions = tmp
+		}
+		solutions = solutions[0 : i+1]
+		solutions[i] = sol
+	}
+	return
+}
+
 func EncodeCoords(r, c int) uint8 { return uint8(16*r + c) }
 
 func DecodeCoords(f uint8) (int, int) { return int(f) / 16, int(f) % 16 }
@@ -236,27 +399,42 @@ type Strategy struct {
 	IfHit, IfMiss *Strategy
 }
 
-// Create a greedy strategy for the given set of solutions:
-func CreateStrategy(solutions []*Field) *Strategy {
+// Create a greedy strategy for the given set of solutions, breaking ties between
+// equally good cells with the given random number generator:
+func CreateStrategy(rng *rand.Rand, solutions []*Field) *Strategy {
+	if len(solutions) == 0 {
+		return nil
+	}
+	weights := make([]float, len(solutions))
+	for i := range (weights) {
+		weights[i] = 1
+	}
+	return createStrategy(rng, solutions, weights, &Field{})
+}
+
+// Create a greedy strategy for the given set of solutions, where each solution
+// occurs with the given (positive) weight. Both slices are reordered.
+func CreateWeightedStrategy(rng *rand.Rand, solutions []*Field, weights []float) *Strategy {
 	if len(solutions) == 0 {
 		return nil
 	}
-	return createStrategy(solutions, &Field{})
+	return createStrategy(rng, solutions, weights, &Field{})
 }
 
 // createStrategy recursively constructs a greedy strategy.
 // TODO: speed up! parallelize!
-func createStrategy(fields []*Field, fired *Field) *Strategy {
-	var hit, miss [FieldHeight][FieldWidth]int
-	var shipsDiscovered, maxHit, maxHitCount int
+func createStrategy(rng *rand.Rand, fields []*Field, weights []float, fired *Field) *Strategy {
+	var hit, miss [FieldHeight][FieldWidth]float
+	var maxHit float
+	var shipsDiscovered, maxHitCount int
 	for r := 0; r < FieldHeight; r++ {
 		for c := 0; c < FieldWidth; c++ {
 			if !fired[r][c] {
-				for _, field := range (fields) {
+				for k, field := range (fields) {
 					if field[r][c] {
-						hit[r][c]++
+						hit[r][c] += weights[k]
 					} else {
-						miss[r][c]++
+						miss[r][c] += weights[k]
 					}
 				}
 				if miss[r][c] == 0 {
@@ -300,7 +478,7 @@ func createStrategy(fields []*Field, fired *Field) *Strategy {
 	}
 
 	// Determine where to fire at next:
-	fireAt := opts[rand.Intn(len(opts))]
+	fireAt := opts[rng.Intn(len(opts))]
 	shots[i] = fireAt
 	fr, fc := DecodeCoords(fireAt)
 	newFired[fr][fc] = true
@@ -313,10 +491,11 @@ func createStrategy(fields []*Field, fired *Field) *Strategy {
 		} else {
 			j--
 			fields[i], fields[j] = fields[j], fields[i]
+			weights[i], weights[j] = weights[j], weights[i]
 		}
 	}
-	ifHit := createStrategy(fields[0:i], &newFired)
-	ifMiss := createStrategy(fields[i:], &newFired)
+	ifHit := createStrategy(rng, fields[0:i], weights[0:i], &newFired)
+	ifMiss := createStrategy(rng, fields[i:], weights[i:], &newFired)
 	return &Strategy{shots, ifHit, ifMiss}
 }
 
@@ -328,6 +507,24 @@ func GetMaximumScore(strategy *Strategy) int {
 	return len(strategy.Shots) + util.Max(GetMaximumScore(strategy.IfHit), GetMaximumScore(strategy.IfMiss))
 }
 
+// Returns the number of shots the given strategy fires before all ships in the
+// given field are sunk (i.e. the depth of the field in the strategy tree)
+func GetFieldScore(strategy *Strategy, field *Field) (score int) {
+	for strategy != nil {
+		score += len(strategy.Shots)
+		if strategy.IfHit == nil && strategy.IfMiss == nil {
+			break
+		}
+		r, c := DecodeCoords(strategy.Shots[len(strategy.Shots)-1])
+		if field[r][c] {
+			strategy = strategy.IfHit
+		} else {
+			strategy = strategy.IfMiss
+		}
+	}
+	return
+}
+
 // Returns the expected score using the given strategy (assuming all solutions
 // occur with equal probability)
 func GetExpectedScore(strategy *Strategy) float {
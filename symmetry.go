@@ -0,0 +1,139 @@
+package game
+
+// Symmetries of the board. Rotating or reflecting the board permutes the row
+// and column counts, so the eight variants of a pair of counts have the same
+// solutions up to symmetry. The solver only solves a canonical representative.
+
+import "rand"
+
+// A Transform is one of the eight symmetries of the (square) board: the bits
+// indicate whether rows and columns are swapped (4), and whether the resulting
+// rows (1) and columns (2) are reversed, in that order.
+type Transform int
+
+const Transforms = 8
+
+// Coords returns the coordinates that r,c are mapped to.
+func (t Transform) Coords(r, c int) (int, int) {
+	if t&4 != 0 {
+		r, c = c, r
+	}
+	if t&1 != 0 {
+		r = FieldHeight - 1 - r
+	}
+	if t&2 != 0 {
+		c = FieldWidth - 1 - c
+	}
+	return r, c
+}
+
+// Field returns a transformed copy of the given field.
+func (t Transform) Field(field *Field) *Field {
+	var result Field
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			r2, c2 := t.Coords(r, c)
+			result[r2][c2] = field[r][c]
+		}
+	}
+	return &result
+}
+
+// Counts returns the row and column counts of the transformed board.
+func (t Transform) Counts(rows RowCounts, cols ColCounts) (RowCounts, ColCounts) {
+	if t&4 != 0 {
+		rows, cols = RowCounts(cols), ColCounts(rows)
+	}
+	if t&1 != 0 {
+		for i, j := 0, FieldHeight-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if t&2 != 0 {
+		for i, j := 0, FieldWidth-1; i < j; i, j = i+1, j-1 {
+			cols[i], cols[j] = cols[j], cols[i]
+		}
+	}
+	return rows, cols
+}
+
+// Inverse returns the transform that undoes t.
+func (t Transform) Inverse() Transform {
+	if t&4 == 0 {
+		return t
+	}
+	return 4 | (t&1)<<1 | (t&2)>>1
+}
+
+// Shots returns a transformed copy of the given shots.
+func (t Transform) Shots(shots []Shot) []Shot {
+	result := make([]Shot, len(shots))
+	for i, shot := range (shots) {
+		result[i].R, result[i].C = t.Coords(shot.R, shot.C)
+		result[i].Hit, result[i].Sunk = shot.Hit, shot.Sunk
+	}
+	return result
+}
+
+// lessCounts compares two pairs of counts lexicographically.
+func lessCounts(rows1 *RowCounts, cols1 *ColCounts, rows2 *RowCounts, cols2 *ColCounts) bool {
+	for i := range (rows1) {
+		if rows1[i] != rows2[i] {
+			return rows1[i] < rows2[i]
+		}
+	}
+	for i := range (cols1) {
+		if cols1[i] != cols2[i] {
+			return cols1[i] < cols2[i]
+		}
+	}
+	return false
+}
+
+// preservesLand returns whether the transform maps the land cells of the board
+// onto themselves, so that it maps solutions onto solutions.
+func (t Transform) preservesLand() bool {
+	land := t.Field(&GameRules.Land)
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if land[r][c] != GameRules.Land[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// randomTransform returns a random transform that preserves the land cells.
+func randomTransform(rng *rand.Rand) Transform {
+	for {
+		if t := Transform(rng.Intn(Transforms)); t.preservesLand() {
+			return t
+		}
+	}
+	panic("unreachable")
+}
+
+// Canonicalize returns the canonical representative of the given counts under
+// the symmetries of the board (the lexicographically smallest variant), and
+// the transform that maps the original board onto it. Only transforms that
+// preserve the land cells of the board are considered.
+func Canonicalize(rows RowCounts, cols ColCounts) (RowCounts, ColCounts, Transform) {
+	bestRows, bestCols, best := rows, cols, Transform(0)
+	for t := Transform(1); t < Transforms; t++ {
+		if !t.preservesLand() {
+			continue
+		}
+		r, c := t.Counts(rows, cols)
+		if lessCounts(&r, &c, &bestRows, &bestCols) {
+			bestRows, bestCols, best = r, c, t
+		}
+	}
+	return bestRows, bestCols, best
+}
+
+// CanonicalKey returns a string that identifies the given counts up to symmetry.
+func CanonicalKey(rows RowCounts, cols ColCounts) string {
+	rows, cols, _ = Canonicalize(rows, cols)
+	return getCacheKey(rows, cols)
+}
@@ -0,0 +1,279 @@
+package game
+
+// Set-ups are generated from templates: pairs of row and column counts with
+// many solutions, which are hard to solve for the opponent. A random solution
+// of a random template is used, after applying a random rotation or reflection
+// of the board.
+
+import (
+	"io"
+	"os"
+	"rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Template is a pair of row and column counts from which set-ups are drawn.
+type Template struct {
+	Rows   RowCounts
+	Cols   ColCounts
+	Weight float // relative probability of selecting this template
+
+	solutions []*Field // computed on first use
+	solved    bool
+	mutex     sync.Mutex
+	hard      []*Field // see HardFields
+	hardMutex sync.Mutex
+
+	count      int   // see Stats
+	expected   float // see Stats
+	hasStats   bool
+	statsMutex sync.Mutex
+}
+
+// DefaultTemplate is used when no other templates have been loaded.
+var DefaultTemplate = &Template{
+	Rows:   RowCounts{2, 0, 4, 0, 2, 0, 3, 0, 7, 0, 5, 0, 3, 0, 4, 0},
+	Cols:   ColCounts{1, 1, 2, 2, 2, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1},
+	Weight: 1,
+}
+
+// Templates is the pool of templates that set-ups are drawn from.
+var Templates = []*Template{DefaultTemplate}
+
+// sortedFields sorts fields in row-major order of their cells, with empty
+// cells first.
+type sortedFields []*Field
+
+func (sf sortedFields) Len() int { return len(sf) }
+func (sf sortedFields) Less(i, j int) bool {
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if sf[i][r][c] != sf[j][r][c] {
+				return sf[j][r][c]
+			}
+		}
+	}
+	return false
+}
+func (sf sortedFields) Swap(i, j int) { sf[i], sf[j] = sf[j], sf[i] }
+
+// Solutions returns all solutions of the template. These are computed only
+// once, and then kept in memory. The solver finds them in parallel, so they're
+// sorted to make random set-ups reproducible.
+func (t *Template) Solutions() []*Field {
+	t.mutex.Lock()
+	if !t.solved {
+		t.solutions = ListSolutions(t.Rows, t.Cols)
+		sort.Sort(sortedFields(t.solutions))
+		t.solved = true
+	}
+	t.mutex.Unlock()
+	return t.solutions
+}
+
+// Stats returns the number of solutions of the template, and the expected
+// number of shots the greedy strategy needs to sink one of them. Only these
+// numbers are kept: unless the template was used before, its solutions aren't
+// kept in memory, so that grading a large pool doesn't hold on to all of them.
+func (t *Template) Stats() (solutions int, expected float) {
+	t.statsMutex.Lock()
+	defer t.statsMutex.Unlock()
+	if !t.hasStats {
+		t.mutex.Lock()
+		fields, solved := t.solutions, t.solved
+		t.mutex.Unlock()
+		if !solved {
+			fields = ListSolutions(t.Rows, t.Cols)
+		}
+		t.count = len(fields)
+		if len(fields) > 0 {
+			// CreateStrategy reorders its argument, so pass it a copy:
+			tmp := make([]*Field, len(fields))
+			copy(tmp, fields)
+			t.expected = GetExpectedScore(CreateStrategy(newRand(), tmp))
+		}
+		t.hasStats = true
+	}
+	return t.count, t.expected
+}
+
+// ParseTemplate parses a template from a line as printed by the generator:
+// a weight, row counts and column counts separated by spaces, optionally
+// followed by other fields (which are ignored).
+func ParseTemplate(line string) *Template {
+	parts := SplitFields(line)
+	if len(parts) < 3 {
+		return nil
+	}
+	weight, err := strconv.Atof(parts[0])
+	if err != nil || weight <= 0 {
+		return nil
+	}
+	rows := ParseRows(parts[1])
+	cols := ParseCols(parts[2])
+	if rows == nil || cols == nil {
+		return nil
+	}
+	return &Template{Rows: *rows, Cols: *cols, Weight: weight}
+}
+
+// LoadTemplates replaces the pool of templates with those read from the given
+// file, which contains one template per line. Empty lines and lines starting
+// with '#' are ignored.
+func LoadTemplates(filename string) os.Error {
+	data, err := io.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var templates []*Template
+	for i, line := range (strings.Split(string(data), "\n", 0)) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		template := ParseTemplate(line)
+		if template == nil {
+			return os.NewError(filename + ":" + strconv.Itoa(i+1) + ": invalid template")
+		}
+		if len(templates) == cap(templates) {
+			tmp := make([]*Template, len(templates), 2*len(templates)+16)
+			copy(tmp, templates)
+			templates = tmp
+		}
+		templates = templates[0 : len(templates)+1]
+		templates[len(templates)-1] = template
+	}
+	if len(templates) == 0 {
+		return os.NewError(filename + ": no templates found")
+	}
+	Templates = templates
+
+	// Pools graded from the previous templates are no longer valid:
+	gradedMutex.Lock()
+	gradedPools, graded = [Difficulties][]*Template{}, false
+	gradedMutex.Unlock()
+	return nil
+}
+
+// selectTemplate selects a random template from the given pool, with each
+// template's probability proportional to its weight.
+func selectTemplate(rng *rand.Rand, templates []*Template) *Template {
+	var total float
+	for _, t := range (templates) {
+		total += t.Weight
+	}
+	x := rng.Float() * total
+	for _, t := range (templates) {
+		if x < t.Weight {
+			return t
+		}
+		x -= t.Weight
+	}
+	return templates[len(templates)-1]
+}
+
+// setupFromTemplates selects a random solution of a random template in the
+// pool, under a random symmetry of the board.
+func setupFromTemplates(rng *rand.Rand, templates []*Template) *Field {
+	solutions := selectTemplate(rng, templates).Solutions()
+	if len(solutions) == 0 {
+		return RandomField(rng) // unsolvable template
+	}
+	return randomTransform(rng).Field(solutions[rng.Intn(len(solutions))])
+}
+
+// Difficulty levels for set-ups:
+const (
+	Easy = iota
+	Medium
+	Hard
+	Difficulties
+)
+
+var difficultyNames = [Difficulties]string{"easy", "medium", "hard"}
+
+// ParseDifficulty parses the name of a difficulty level.
+func ParseDifficulty(name string) (int, bool) {
+	for level, levelName := range (difficultyNames) {
+		if name == levelName {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// gradedTemplates sorts templates by difficulty.
+type gradedTemplates []*Template
+
+func (gt gradedTemplates) Len() int { return len(gt) }
+func (gt gradedTemplates) Less(i, j int) bool {
+	si, ei := gt[i].Stats()
+	sj, ej := gt[j].Stats()
+	return ei < ej || ei == ej && si < sj
+}
+func (gt gradedTemplates) Swap(i, j int) { gt[i], gt[j] = gt[j], gt[i] }
+
+var gradedPools [Difficulties][]*Template // templates by difficulty level
+var graded bool                           // whether Templates have been graded
+var gradedMutex sync.Mutex
+
+// GradeTemplates divides the given templates in pools by difficulty, ranking
+// them by the expected score of the greedy strategy (and then by number of
+// solutions). The templates are spread evenly over the pools, with the hardest
+// pools filled first when there are fewer templates than difficulty levels.
+func GradeTemplates(templates []*Template) (pools [Difficulties][]*Template) {
+	sorted := make(gradedTemplates, len(templates))
+	copy(sorted, templates)
+	sort.Sort(sorted)
+	n := len(sorted)
+	var counts [Difficulties]int
+	level := func(i int) int { return Hard - (n-1-i)*Difficulties/n }
+	for i := range (sorted) {
+		counts[level(i)]++
+	}
+	for l := range (pools) {
+		pools[l] = make([]*Template, 0, counts[l])
+	}
+	for i, t := range (sorted) {
+		l := level(i)
+		pools[l] = pools[l][0 : len(pools[l])+1]
+		pools[l][len(pools[l])-1] = t
+	}
+	return
+}
+
+// GradeTemplatePools grades the current pool of templates by difficulty (see
+// GradeTemplates) for use by SetupWithDifficulty, and returns the graded pools.
+// This solves every template and builds a strategy for it, which can take a
+// long time for a large pool, so it's only done once, when first needed
+// (unless the templates are reloaded).
+func GradeTemplatePools() [Difficulties][]*Template {
+	gradedMutex.Lock()
+	defer gradedMutex.Unlock()
+	if !graded {
+		gradedPools, graded = GradeTemplates(Templates), true
+	}
+	return gradedPools
+}
+
+// SetupWithDifficulty returns a random field set-up of the given difficulty
+// level, drawn from the current pool of templates graded by GradeTemplatePools
+// (which is called first if necessary). If there are no templates of the
+// requested level, an easier pool is used, and ultimately a field with randomly
+// placed ships. Notably, with only the default template, which is graded as
+// hard, easy and medium set-ups are placed at random.
+func SetupWithDifficulty(rng *rand.Rand, level int) *Field {
+	pools := GradeTemplatePools()
+	for ; level >= 0; level-- {
+		if len(pools[level]) > 0 {
+			return setupFromTemplates(rng, pools[level])
+		}
+	}
+	return RandomField(rng)
+}
+
+// DifficultyName returns the name of a difficulty level.
+func DifficultyName(level int) string { return difficultyNames[level] }
@@ -104,15 +104,37 @@ func main() {
 	colsFlag := flag.String("Cols", "", "Solve a field with the given column counts (requires -Rows as well)")
 	seedFlag := flag.Int64("Seed", 0, "Random seed (0 to pick at random)")
 	shotsFlag := flag.String("Shots", "-", "Specify previous shots, and request the next move")
+	engineFlag := flag.String("Engine", "default", "Engine used to set up fields and fire shots")
+	templatesFlag := flag.String("Templates", "", "File with set-up templates")
 	flag.FloatVar(&game.TimeOut, "TimeOut", game.TimeOut, "Maximum time to spend on solving")
+	rules := game.RegisterRuleFlags(true)
 	flag.Parse()
 
+	if err := rules.Apply(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *templatesFlag != "" {
+		if err := game.LoadTemplates(*templatesFlag); err != nil {
+			fmt.Println("Couldn't load templates:", err)
+			return
+		}
+	}
+
+	engine := game.LookupEngine(*engineFlag)
+	if engine == nil {
+		fmt.Println("Unknown engine:", *engineFlag)
+		return
+	}
+
 	// Seed pseudo-random number generator:
 	if *seedFlag != 0 {
 		rand.Seed(*seedFlag)
 	} else {
 		rand.Seed(time.Nanoseconds())
 	}
+	rng := rand.New(rand.NewSource(rand.Int63()))
 
 	var rows game.RowCounts
 	var cols game.ColCounts
@@ -122,6 +144,12 @@ func main() {
 		if field := game.ParseShips(*shipsFlag); field == nil {
 			fmt.Println("Couldn't parse field description:", *shipsFlag)
 			return
+		} else if _, violations := game.ValidateFleet(field); violations != nil {
+			fmt.Println("Invalid fleet:", *shipsFlag)
+			for _, violation := range (violations) {
+				fmt.Println("\t" + violation)
+			}
+			return
 		} else {
 			rows, cols = game.CountShips(field)
 		}
@@ -141,7 +169,7 @@ func main() {
 		}
 	} else if *setupFlag {
 		// Set up a random field:
-		field := game.Setup()
+		field := engine.Setup(rng)
 		fmt.Println("Random setup:", game.FormatShips(field))
 		rows, cols = game.CountShips(field)
 	} else {
@@ -155,7 +183,7 @@ func main() {
 		fmt.Println("Cols:", game.FormatCounts(&cols))
 		solutions := game.ListSolutions(rows, cols)
 		fmt.Println(len(solutions), "solutions found.")
-		strategy := game.CreateStrategy(solutions)
+		strategy := game.CreateStrategy(rng, solutions)
 		fmt.Println("Expected score:", game.GetExpectedScore(strategy))
 		wc := game.GetMaximumScore(strategy)
 		fmt.Println("Worst-case score:", wc)
@@ -174,7 +202,7 @@ func main() {
 			fmt.Println("Couldn't parse shots:", *shotsFlag)
 		} else {
 			// Determine best move:
-			r, c := game.Shoot(rows, cols, shots)
+			r, c := engine.Shoot(rng, rows, cols, shots)
 			fmt.Println(game.FormatCoords(r, c))
 		}
 	}
@@ -0,0 +1,258 @@
+package game
+
+// Validation of set-ups. A field only records which cells are occupied, so to
+// check a set-up, the field is first decomposed into ships, which are then
+// compared with the fleet of the rules.
+
+import "strconv"
+
+// componentAt returns the cells of the group of segments connected (along
+// their sides) to cell r,c, in row-major order, and marks them as seen.
+func componentAt(field *Field, seen *Field, r, c int) [][2]int {
+	cells := make([][2]int, 1, FieldHeight*FieldWidth)
+	cells[0] = [2]int{r, c}
+	seen[r][c] = true
+	for i := 0; i < len(cells); i++ {
+		for _, d := range (directions) {
+			if r, c := cells[i][0]+d[0], cells[i][1]+d[1]; inField(r, c) && field[r][c] && !seen[r][c] {
+				seen[r][c] = true
+				cells = cells[0 : len(cells)+1]
+				cells[len(cells)-1] = [2]int{r, c}
+			}
+		}
+	}
+	sortCells(cells)
+	return cells
+}
+
+// matchShape returns the placement of a ship of the given shape that covers
+// exactly the given cells (in row-major order), if there is one.
+func matchShape(shape *Shape, cells [][2]int) (Ship, bool) {
+	if shape.Size() != len(cells) {
+		return Ship{}, false
+	}
+orientations:
+	for orientation, o := range (shape.Orientations) {
+		for i, cell := range (o.Cells) {
+			if cells[i][0]-cells[0][0] != cell[0] || cells[i][1]-cells[0][1] != cell[1] {
+				continue orientations
+			}
+		}
+		return Ship{shape, orientation, cells[0][0], cells[0][1]}, true
+	}
+	return Ship{}, false
+}
+
+// identifyShip returns the placement of a ship that covers exactly the given
+// cells: preferably a ship of the fleet, or otherwise any polyomino or straight
+// ship, so that ships that don't belong to the fleet can be reported.
+func identifyShip(cells [][2]int) (Ship, bool) {
+	for _, shape := range (GameRules.Fleet) {
+		if p, ok := matchShape(shape, cells); ok {
+			return p, true
+		}
+	}
+	for _, shape := range (Polyominoes) {
+		if p, ok := matchShape(shape, cells); ok {
+			return p, true
+		}
+	}
+	return matchShape(StraightShape(len(cells)), cells)
+}
+
+// describeShips describes a number of ships of the given shape.
+func describeShips(count int, shape *Shape) string {
+	ships := " ships"
+	if count == 1 {
+		ships = " ship"
+	}
+	if shape.Straight() {
+		return strconv.Itoa(count) + ships + " of length " + shape.Name
+	}
+	return strconv.Itoa(count) + ships + " of shape " + shape.Name
+}
+
+// newReport returns a function that records a violation of the rules, and one
+// that returns the violations recorded (or nil if there are none).
+func newReport() (report func(string), reported func() []string) {
+	violations := make([]string, 0, 16)
+	report = func(violation string) {
+		if len(violations) == cap(violations) {
+			tmp := make([]string, len(violations), 2*len(violations))
+			copy(tmp, violations)
+			violations = tmp
+		}
+		violations = violations[0 : len(violations)+1]
+		violations[len(violations)-1] = violation
+	}
+	reported = func() []string {
+		if len(violations) == 0 {
+			return nil
+		}
+		return violations
+	}
+	return
+}
+
+// compareWithFleet reports the shapes of which the number of ships differs
+// from the fleet.
+func compareWithFleet(ships []Ship, report func(string)) {
+	expected, found := make(map[string]int), make(map[string]int)
+	for _, shape := range (GameRules.Fleet) {
+		expected[shape.Name]++
+	}
+	for _, p := range (ships) {
+		found[p.Shape.Name]++
+	}
+	for i, shape := range (GameRules.Fleet) {
+		if (i == 0 || GameRules.Fleet[i-1].Name != shape.Name) && found[shape.Name] != expected[shape.Name] {
+			report("found " + describeShips(found[shape.Name], shape) +
+				", expected " + strconv.Itoa(expected[shape.Name]))
+		}
+	}
+	for _, p := range (ships) {
+		if name := p.Shape.Name; expected[name] == 0 && found[name] > 0 {
+			report("found " + describeShips(found[name], p.Shape) + ", expected none")
+			found[name] = 0 // report each shape once
+		}
+	}
+}
+
+// partitionShips explains why a field, where ships may touch, can't be divided
+// into the ships of the fleet. It reports a wrong number of segments, and each
+// group of connected segments that can't be divided into ships of the fleet's
+// shapes (using each shape any number of times). The ships found in the other
+// groups are returned, so that they can be compared with the fleet.
+func partitionShips(field *Field, report func(string)) []Ship {
+	segments, expected := 0, 0
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if field[r][c] {
+				segments++
+			}
+		}
+	}
+	for _, shape := range (GameRules.Fleet) {
+		expected += shape.Size()
+	}
+	if segments != expected {
+		report("found " + strconv.Itoa(segments) + " segments, expected " + strconv.Itoa(expected))
+	}
+
+	ships := make([]Ship, 0, segments)
+	var seen Field
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if !field[r][c] || seen[r][c] {
+				continue
+			}
+			var group Field
+			cells := componentAt(field, &seen, r, c)
+			for _, cell := range (cells) {
+				group[cell[0]][cell[1]] = true
+			}
+			fs := &fleetSearch{field: &group, placed: make([]Ship, 0, len(cells))}
+			if !fs.search() {
+				report("segments at " + FormatCoords(r, c) + " can't be divided into ships of the fleet")
+				continue
+			}
+			for _, p := range (fs.placed) {
+				ships = ships[0 : len(ships)+1]
+				ships[len(ships)-1] = p
+			}
+		}
+	}
+	return ships
+}
+
+// ValidateFleet decomposes a field into ships, and checks that these are
+// exactly the ships of the fleet, placed according to the rules. It returns the
+// ships found, and a description of each violation of the rules (or nil if the
+// field is a valid set-up).
+func ValidateFleet(field *Field) (ships []Ship, violations []string) {
+	report, reported := newReport()
+
+	// Segments on land are reported, and otherwise ignored:
+	sea := *field
+	for r := 0; r < FieldHeight; r++ {
+		for c := 0; c < FieldWidth; c++ {
+			if sea[r][c] && GameRules.Land[r][c] {
+				report("segment on land at " + FormatCoords(r, c))
+				sea[r][c] = false
+			}
+		}
+	}
+
+	ships = make([]Ship, 0, FieldHeight*FieldWidth)
+	if GameRules.Adjacency == NoTouch {
+		// Each group of connected segments must be a single ship:
+		var seen Field
+		var group [FieldHeight][FieldWidth]int
+		groups := 0
+		for r := 0; r < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				if !sea[r][c] || seen[r][c] {
+					continue
+				}
+				cells := componentAt(&sea, &seen, r, c)
+				groups++
+				for _, cell := range (cells) {
+					group[cell[0]][cell[1]] = groups
+				}
+				if p, ok := identifyShip(cells); !ok {
+					report("segments at " + FormatCoords(r, c) + " don't form a ship")
+				} else {
+					ships = ships[0 : len(ships)+1]
+					ships[len(ships)-1] = p
+				}
+			}
+		}
+
+		// Ships may not touch diagonally either:
+		for r := 0; r+1 < FieldHeight; r++ {
+			for c := 0; c < FieldWidth; c++ {
+				for dc := -1; dc <= 1; dc += 2 {
+					if c+dc >= 0 && c+dc < FieldWidth && sea[r][c] && sea[r+1][c+dc] &&
+						group[r][c] != group[r+1][c+dc] {
+						report("ships touch at " + FormatCoords(r, c) + " and " + FormatCoords(r+1, c+dc))
+					}
+				}
+			}
+		}
+	} else {
+		n := len(GameRules.Fleet)
+		fs := &fleetSearch{field: &sea, used: make([]bool, n), placed: make([]Ship, 0, n)}
+		if fs.search() {
+			ships = fs.placed
+		} else {
+			ships = partitionShips(&sea, report)
+		}
+	}
+
+	compareWithFleet(ships, report)
+	return ships, reported()
+}
+
+// ValidateShips checks that a list of ships, as placed by a player, consists of
+// exactly the ships of the fleet, placed according to the rules. Unlike
+// ValidateFleet, this doesn't need to decompose a field, so it also applies
+// where ships may touch and the field alone is ambiguous. It returns a
+// description of each violation of the rules, or nil if there are none.
+func ValidateShips(ships []Ship) []string {
+	report, reported := newReport()
+	for i, p := range (ships) {
+		switch {
+		case !p.InField():
+			report("ship " + p.String() + " lies outside the field")
+		case !p.Valid():
+			report("ship " + p.String() + " is on land")
+		}
+		for _, q := range (ships[0:i]) {
+			if p.Conflicts(q) {
+				report("ships " + q.String() + " and " + p.String() + " overlap or touch")
+			}
+		}
+	}
+	compareWithFleet(ships, report)
+	return reported()
+}